@@ -0,0 +1,244 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	BlockSize = 4096
+	// MaxPinnedShare part of the block cache capacity pinned blocks can take, blocks over it are not pinned.
+	MaxPinnedShare = 0.5
+)
+
+type blockKey struct {
+	table  string
+	offset int64
+}
+
+type blockEntry struct {
+	key    blockKey
+	data   []byte
+	pinned bool
+}
+
+type BlockCache struct {
+	mutex        sync.Mutex
+	capacity     int64
+	used         int64
+	pinned       int64
+	maxPinned    int64
+	pinIndex     bool
+	listOfBlocks *list.List
+	pinnedBlocks *list.List
+	blocks       map[blockKey]*list.Element
+	hits         uint64
+	misses       uint64
+}
+
+// NewBlockCache returns a new BlockCache which holds at most capacity bytes of SSTable blocks. If pinIndex is true,
+// summary, index and filter blocks are pinned and never evicted, as long as pinned blocks take at most MaxPinnedShare
+// of the capacity. Pinned blocks are kept apart from the others, so eviction never has to skip them.
+func NewBlockCache(capacity int64, pinIndex bool) (*BlockCache, error) {
+	if capacity <= 0 {
+		return nil, errors.New("size of block cache must be grater than 0")
+	}
+	return &BlockCache{
+		capacity:     capacity,
+		maxPinned:    int64(float64(capacity) * MaxPinnedShare),
+		pinIndex:     pinIndex,
+		listOfBlocks: list.New(),
+		pinnedBlocks: list.New(),
+		blocks:       make(map[blockKey]*list.Element),
+	}, nil
+}
+
+// block returns the block of the given file which starts at the given offset, reading it from disk on a miss.
+func (bc *BlockCache) block(path string, offset int64, pin bool) ([]byte, error) {
+	key := blockKey{table: path, offset: offset}
+	bc.mutex.Lock()
+	if entry, ok := bc.blocks[key]; ok {
+		bc.blockList(entry).MoveToFront(entry)
+		bc.hits++
+		bc.mutex.Unlock()
+		return entry.Value.(*blockEntry).data, nil
+	}
+	bc.misses++
+	bc.mutex.Unlock()
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	data := make([]byte, BlockSize)
+	n, err := file.ReadAt(data, offset)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	data = data[:n]
+
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	if entry, ok := bc.blocks[key]; ok {
+		return entry.Value.(*blockEntry).data, nil
+	}
+	pin = pin && bc.pinned+int64(len(data)) <= bc.maxPinned
+	ent := &blockEntry{key: key, data: data, pinned: pin}
+	if pin {
+		bc.blocks[key] = bc.pinnedBlocks.PushFront(ent)
+		bc.pinned += int64(len(data))
+	} else {
+		bc.blocks[key] = bc.listOfBlocks.PushFront(ent)
+	}
+	bc.used += int64(len(data))
+	bc.evict()
+	return data, nil
+}
+
+// blockList returns the list which holds the block, pinned or not.
+func (bc *BlockCache) blockList(entry *list.Element) *list.List {
+	if entry.Value.(*blockEntry).pinned {
+		return bc.pinnedBlocks
+	}
+	return bc.listOfBlocks
+}
+
+// evict removes the least recently used blocks that are not pinned until the cache is under its capacity.
+func (bc *BlockCache) evict() {
+	for bc.used > bc.capacity && bc.listOfBlocks.Len() > 0 {
+		bc.removeElement(bc.listOfBlocks.Back())
+	}
+}
+
+// removeElement removes the block from the cache.
+func (bc *BlockCache) removeElement(entry *list.Element) {
+	ent := entry.Value.(*blockEntry)
+	bc.blockList(entry).Remove(entry)
+	delete(bc.blocks, ent.key)
+	bc.used -= int64(len(ent.data))
+	if ent.pinned {
+		bc.pinned -= int64(len(ent.data))
+	}
+}
+
+// RemoveTable removes all blocks of the SSTable in the given directory, it has to be called when an SSTable is
+// removed, because the same directory name can be used again.
+func (bc *BlockCache) RemoveTable(directoryPath string) {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	for key, entry := range bc.blocks {
+		if strings.HasPrefix(key.table, directoryPath+"/") {
+			bc.removeElement(entry)
+		}
+	}
+}
+
+// Hits returns the number of block cache hits.
+func (bc *BlockCache) Hits() uint64 {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.hits
+}
+
+// Misses returns the number of block cache misses.
+func (bc *BlockCache) Misses() uint64 {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	return bc.misses
+}
+
+// Info prints out BlockCache data.
+func (bc *BlockCache) Info() {
+	bc.mutex.Lock()
+	defer bc.mutex.Unlock()
+	fmt.Println("Capacity (bytes):", bc.capacity)
+	fmt.Println("Used (bytes):", bc.used)
+	fmt.Println("Pinned (bytes):", bc.pinned)
+	fmt.Println("Blocks:", len(bc.blocks))
+	fmt.Println("Hits:", bc.hits)
+	fmt.Println("Misses:", bc.misses)
+	if bc.hits+bc.misses > 0 {
+		fmt.Printf("Hit ratio: %.2f\n", float64(bc.hits)/float64(bc.hits+bc.misses))
+	}
+}
+
+type tableFile interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+type cachedFile struct {
+	cache *BlockCache
+	path  string
+	pos   int64
+	size  int64
+	pin   bool
+}
+
+// openTableFile opens an SSTable file for reading. If cache is nil the file is read directly from disk, otherwise all
+// reads go through the block cache. Summary, index and filter files are pinned if the cache is configured to.
+func openTableFile(cache *BlockCache, path string, indexFile bool) (tableFile, error) {
+	if cache == nil {
+		return os.OpenFile(path, os.O_RDONLY, 0666)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedFile{cache: cache, path: path, size: info.Size(), pin: indexFile && cache.pinIndex}, nil
+}
+
+// Read reads from the current position, block by block.
+func (cf *cachedFile) Read(p []byte) (int, error) {
+	if cf.pos >= cf.size {
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(p) && cf.pos < cf.size {
+		blockOffset := cf.pos - cf.pos%BlockSize
+		data, err := cf.cache.block(cf.path, blockOffset, cf.pin)
+		if err != nil {
+			return n, err
+		}
+		start := int(cf.pos - blockOffset)
+		if start >= len(data) {
+			break
+		}
+		copied := copy(p[n:], data[start:])
+		n += copied
+		cf.pos += int64(copied)
+	}
+	return n, nil
+}
+
+// Seek sets the position for the next Read.
+func (cf *cachedFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		cf.pos = offset
+	case io.SeekCurrent:
+		cf.pos += offset
+	case io.SeekEnd:
+		cf.pos = cf.size + offset
+	}
+	if cf.pos < 0 {
+		cf.pos = 0
+		return 0, errors.New("seek before the start of the file")
+	}
+	return cf.pos, nil
+}
+
+// Close does nothing, blocks stay in the cache.
+func (cf *cachedFile) Close() error {
+	return nil
+}
@@ -0,0 +1,101 @@
+package Structures
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeBlocks writes a file of the given number of full blocks into the table directory and returns its path.
+func writeBlocks(t *testing.T, table string, name string, blocks int) string {
+	if err := os.MkdirAll(table, 0777); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(table, name)
+	if err := os.WriteFile(path, make([]byte, blocks*BlockSize), 0666); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBlockCacheHitsAndEvicts(t *testing.T) {
+	data := writeBlocks(t, filepath.Join(t.TempDir(), "SSTable1"), "sstable-data.dat", 3)
+	cache, err := NewBlockCache(2*BlockSize, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, offset := range []int64{0, 0, BlockSize, 2 * BlockSize, 0} {
+		if _, err := cache.block(data, offset, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first block is evicted by the third one, so it is read again.
+	if cache.Hits() != 1 || cache.Misses() != 4 {
+		t.Errorf("%d hits and %d misses, expected 1 and 4", cache.Hits(), cache.Misses())
+	}
+	if cache.used > cache.capacity {
+		t.Errorf("%d bytes used over the capacity %d", cache.used, cache.capacity)
+	}
+}
+
+func TestBlockCachePinsUpToShare(t *testing.T) {
+	table := filepath.Join(t.TempDir(), "SSTable1")
+	index := writeBlocks(t, table, "sstable-index.dat", 3)
+	data := writeBlocks(t, table, "sstable-data.dat", 4)
+	cache, err := NewBlockCache(4*BlockSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for offset := int64(0); offset < 3*BlockSize; offset += BlockSize {
+		if _, err := cache.block(index, offset, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cache.pinned != 2*BlockSize {
+		t.Fatalf("%d bytes pinned, expected at most half of the capacity", cache.pinned)
+	}
+	for offset := int64(0); offset < 4*BlockSize; offset += BlockSize {
+		if _, err := cache.block(data, offset, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cache.listOfBlocks.Len() != 2 {
+		t.Errorf("%d unpinned blocks cached, expected the capacity left by pinned blocks", cache.listOfBlocks.Len())
+	}
+	hits := cache.Hits()
+	for offset := int64(0); offset < 2*BlockSize; offset += BlockSize {
+		if _, err := cache.block(index, offset, true); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cache.Hits() != hits+2 {
+		t.Error("pinned blocks were evicted")
+	}
+}
+
+func TestBlockCacheRemoveTable(t *testing.T) {
+	dir := t.TempDir()
+	first := writeBlocks(t, filepath.Join(dir, "SSTable1"), "sstable-index.dat", 1)
+	second := writeBlocks(t, filepath.Join(dir, "SSTable2"), "sstable-data.dat", 1)
+	cache, err := NewBlockCache(4*BlockSize, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{first, second} {
+		if _, err := cache.block(path, 0, path == first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cache.RemoveTable(filepath.Join(dir, "SSTable1"))
+	if cache.used != BlockSize || cache.pinned != 0 || len(cache.blocks) != 1 {
+		t.Errorf("%d bytes used, %d pinned, %d blocks after the table is removed", cache.used, cache.pinned,
+			len(cache.blocks))
+	}
+	misses := cache.Misses()
+	if _, err := cache.block(first, 0, true); err != nil {
+		t.Fatal(err)
+	}
+	if cache.Misses() != misses+1 {
+		t.Error("block of the removed table is still cached")
+	}
+}
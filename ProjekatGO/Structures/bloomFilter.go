@@ -8,6 +8,7 @@ import (
 	"github.com/Workiva/go-datastructures/bitarray"
 	"github.com/spaolacci/murmur3"
 	"hash"
+	"io"
 	"math"
 	"os"
 )
@@ -111,26 +112,30 @@ func (bf *BloomFilter) Serialize(fileName string) {
 		fmt.Println(err)
 		return
 	}
-	bytes := make([]byte, 20)
-	binary.LittleEndian.PutUint32(bytes[:4], bf.m)
-	binary.LittleEndian.PutUint32(bytes[4:8], bf.k)
-	binary.LittleEndian.PutUint32(bytes[8:12], bf.n)
-	binary.LittleEndian.PutUint64(bytes[12:20], math.Float64bits(bf.p))
-	setBytes, err := bitarray.Marshal(bf.set)
+	err = bf.Write(file)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	bytes = append(bytes, setBytes...)
-	_, err = file.Write(bytes)
+	err = file.Close()
 	if err != nil {
-		fmt.Println(err)
 		return
 	}
-	err = file.Close()
+}
+
+// Write writes serialized BloomFilter to the given writer.
+func (bf *BloomFilter) Write(w io.Writer) error {
+	bytes := make([]byte, 20)
+	binary.LittleEndian.PutUint32(bytes[:4], bf.m)
+	binary.LittleEndian.PutUint32(bytes[4:8], bf.k)
+	binary.LittleEndian.PutUint32(bytes[8:12], bf.n)
+	binary.LittleEndian.PutUint64(bytes[12:20], math.Float64bits(bf.p))
+	setBytes, err := bitarray.Marshal(bf.set)
 	if err != nil {
-		return
+		return err
 	}
+	_, err = w.Write(append(bytes, setBytes...))
+	return err
 }
 
 // DeserializeFilter creates a new BloomFilter from the given file.
@@ -140,6 +145,11 @@ func DeserializeFilter(fileName string) *BloomFilter {
 		fmt.Println(err)
 		return nil
 	}
+	return deserializeFilterBytes(bytes)
+}
+
+// deserializeFilterBytes creates a new BloomFilter from serialized bytes.
+func deserializeFilterBytes(bytes []byte) *BloomFilter {
 	m := binary.LittleEndian.Uint32(bytes[:4])
 	k := binary.LittleEndian.Uint32(bytes[4:8])
 	n := binary.LittleEndian.Uint32(bytes[8:12])
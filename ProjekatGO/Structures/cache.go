@@ -6,101 +6,366 @@ import (
 	"container/list"
 	"errors"
 	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
 )
 
 //NOTE: problems while decoding a []byte type value
 
+const (
+	DefaultCacheShards = 16
+)
+
+// Cache is implemented by all cache eviction policies. Values start with the tombstone byte, deleted values are kept
+// in the cache but GetFromCache reports them as missing, GetEntryFromCache returns them with the tombstone byte.
+type Cache interface {
+	AddToCache(key string, value []byte) (evicted bool)
+	GetFromCache(key string) ([]byte, bool)
+	GetEntryFromCache(key string) ([]byte, bool)
+	RemoveFromCache(key string) (present bool)
+}
+
+// NewCache creates the cache with policy ("lru", "lfu", "arc" or "tinylfu") and size given in configuration. Byte
+// capacity and TTL are supported by the lru policy.
+func NewCache(config *Config) (Cache, error) {
+	size := int(config.CacheSize)
+	switch config.CachePolicy {
+	case "", "lru":
+		shards := config.CacheShards
+		if shards <= 0 {
+			shards = DefaultCacheShards
+		}
+		cLRU, err := NewShardedCacheLRU(size, shards)
+		if err != nil {
+			return nil, err
+		}
+		cLRU.SetMaxBytes(config.CacheSizeBytes)
+		cLRU.SetTTL(time.Duration(config.CacheTTL) * time.Second)
+		cLRU.StartExpiry(time.Duration(config.CacheExpiryInterval) * time.Second)
+		return cLRU, nil
+	case "lfu":
+		return NewCacheLFU(size)
+	case "arc":
+		return NewCacheARC(size)
+	case "tinylfu":
+		return NewCacheTinyLFU(size)
+	}
+	return nil, errors.New("unknown cache policy: " + config.CachePolicy)
+}
+
+// cachedValue returns the value if it isn't deleted.
+func cachedValue(value []byte) ([]byte, bool) {
+	if len(value) == 0 || string(value[0]) == "1" {
+		return nil, false
+	}
+	return value, true
+}
+
+type EvictionReason int
+
+const (
+	EvictedCapacity EvictionReason = iota
+	EvictedExpired
+	EvictedRemoved
+)
+
+// String returns the name of the EvictionReason.
+func (r EvictionReason) String() string {
+	switch r {
+	case EvictedCapacity:
+		return "capacity"
+	case EvictedExpired:
+		return "expired"
+	}
+	return "removed"
+}
+
+// EvictionCallback is called after an item leaves the cache.
+type EvictionCallback func(key string, value []byte, reason EvictionReason)
+
 type lruEntry struct {
-	key   string
-	value []byte
+	key     string
+	value   []byte
+	expires time.Time
 }
 
-type CacheLRU struct {
+// bytes returns the number of bytes the entry takes.
+func (ent *lruEntry) bytes() int64 {
+	return int64(len(ent.key) + len(ent.value))
+}
+
+// expired checks if the entry has a TTL that passed.
+func (ent *lruEntry) expired(now time.Time) bool {
+	return !ent.expires.IsZero() && now.After(ent.expires)
+}
+
+type evictedEntry struct {
+	entry  *lruEntry
+	reason EvictionReason
+}
+
+type lruShard struct {
+	mutex       sync.Mutex
 	size        int
+	maxBytes    int64
+	usedBytes   int64
 	listOfItems *list.List
 	items       map[string]*list.Element
 }
 
-// NewCacheLRU creates a new structure, and we can start using it from then
+type CacheLRU struct {
+	shards     []*lruShard
+	ttl        time.Duration
+	evictMutex sync.Mutex
+	onEvict    EvictionCallback
+	stop       chan struct{}
+}
+
+// NewCacheLRU creates a new structure with a single shard, and we can start using it from then
 func NewCacheLRU(size int) (*CacheLRU, error) {
+	return NewShardedCacheLRU(size, 1)
+}
+
+// NewShardedCacheLRU creates a new cache split into the given number of shards, each shard has its own lock and an
+// equal part of the size. There are never more shards than the size of the cache.
+func NewShardedCacheLRU(size int, shards int) (*CacheLRU, error) {
 	if size <= 0 {
 		return nil, errors.New("size of cache must be grater than 0")
 	}
-	cLRU := &CacheLRU{
-		size:        size,
-		listOfItems: list.New(),
-		items:       make(map[string]*list.Element),
+	if shards <= 0 {
+		return nil, errors.New("number of cache shards must be grater than 0")
+	}
+	if shards > size {
+		shards = size
+	}
+	cLRU := &CacheLRU{shards: make([]*lruShard, shards)}
+	for i := range cLRU.shards {
+		shardSize := size / shards
+		if i < size%shards {
+			shardSize++
+		}
+		cLRU.shards[i] = &lruShard{
+			size:        shardSize,
+			listOfItems: list.New(),
+			items:       make(map[string]*list.Element),
+		}
 	}
 	return cLRU, nil
 }
 
+// SetMaxBytes limits the number of bytes (keys and values) the cache holds, split equally among shards. 0 means no
+// limit. Must be called before the cache is used.
+func (cLRU *CacheLRU) SetMaxBytes(maxBytes int64) {
+	for _, shard := range cLRU.shards {
+		shard.maxBytes = maxBytes / int64(len(cLRU.shards))
+		if maxBytes > 0 && shard.maxBytes == 0 {
+			shard.maxBytes = 1
+		}
+	}
+}
+
+// SetTTL sets the default TTL of items added with AddToCache, 0 means items never expire. Must be called before the
+// cache is used.
+func (cLRU *CacheLRU) SetTTL(ttl time.Duration) {
+	cLRU.ttl = ttl
+}
+
+// OnEvict sets the callback which is called for every item that leaves the cache. It can be set while the cache is
+// used, also by the expiry goroutine.
+func (cLRU *CacheLRU) OnEvict(callback EvictionCallback) {
+	cLRU.evictMutex.Lock()
+	cLRU.onEvict = callback
+	cLRU.evictMutex.Unlock()
+}
+
+// StartExpiry starts removing expired items every interval, expired items are also removed lazily when they are read.
+func (cLRU *CacheLRU) StartExpiry(interval time.Duration) {
+	if interval <= 0 || cLRU.stop != nil {
+		return
+	}
+	cLRU.stop = make(chan struct{})
+	go func(stop chan struct{}) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				cLRU.RemoveExpired()
+			case <-stop:
+				return
+			}
+		}
+	}(cLRU.stop)
+}
+
+// StopExpiry stops the periodic removal of expired items.
+func (cLRU *CacheLRU) StopExpiry() {
+	if cLRU.stop != nil {
+		close(cLRU.stop)
+		cLRU.stop = nil
+	}
+}
+
+// RemoveExpired removes all expired items from the cache.
+func (cLRU *CacheLRU) RemoveExpired() {
+	now := time.Now()
+	for _, shard := range cLRU.shards {
+		var evicted []evictedEntry
+		shard.mutex.Lock()
+		for entry := shard.listOfItems.Front(); entry != nil; {
+			next := entry.Next()
+			if ent := entry.Value.(*lruEntry); ent.expired(now) {
+				shard.removeElement(entry)
+				evicted = append(evicted, evictedEntry{ent, EvictedExpired})
+			}
+			entry = next
+		}
+		shard.mutex.Unlock()
+		cLRU.notify(evicted)
+	}
+}
+
+// notify calls the eviction callback for evicted items, it is called without holding the shard lock.
+func (cLRU *CacheLRU) notify(evicted []evictedEntry) {
+	cLRU.evictMutex.Lock()
+	onEvict := cLRU.onEvict
+	cLRU.evictMutex.Unlock()
+	if onEvict == nil {
+		return
+	}
+	for _, ev := range evicted {
+		onEvict(ev.entry.key, ev.entry.value, ev.reason)
+	}
+}
+
+// shard returns the shard the key belongs to
+func (cLRU *CacheLRU) shard(key string) *lruShard {
+	if len(cLRU.shards) == 1 {
+		return cLRU.shards[0]
+	}
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key))
+	return cLRU.shards[hash.Sum32()%uint32(len(cLRU.shards))]
+}
+
 // AddToCache adds new item to cacheLRU and if item already exists it just moves it to the front of the list
 func (cLRU *CacheLRU) AddToCache(key string, value []byte) (evicted bool) {
-	if entry, ok := cLRU.items[key]; ok && string(value) == string(entry.Value.(*lruEntry).value) {
-		cLRU.listOfItems.MoveToFront(entry)
-		return false
-	}
+	return cLRU.AddToCacheWithTTL(key, value, cLRU.ttl)
+}
 
-	ent := &lruEntry{key, value}
-	entry := cLRU.listOfItems.PushFront(ent)
-	cLRU.items[key] = entry
+// AddToCacheWithTTL adds new item which expires after ttl (0 means never) to cacheLRU
+func (cLRU *CacheLRU) AddToCacheWithTTL(key string, value []byte, ttl time.Duration) (evicted bool) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	shard := cLRU.shard(key)
+	shard.mutex.Lock()
+	if entry, ok := shard.items[key]; ok {
+		shard.listOfItems.MoveToFront(entry)
+		ent := entry.Value.(*lruEntry)
+		shard.usedBytes += int64(len(value) - len(ent.value))
+		ent.value = value
+		ent.expires = expires
+	} else {
+		ent := &lruEntry{key: key, value: value, expires: expires}
+		shard.items[key] = shard.listOfItems.PushFront(ent)
+		shard.usedBytes += ent.bytes()
+	}
 
-	evict := cLRU.listOfItems.Len() > cLRU.size
-	if evict {
-		cLRU.removeOldest()
+	var evictedEntries []evictedEntry
+	for shard.listOfItems.Len() > 1 && (shard.listOfItems.Len() > shard.size ||
+		(shard.maxBytes > 0 && shard.usedBytes > shard.maxBytes)) {
+		evictedEntries = append(evictedEntries, evictedEntry{shard.removeOldest(), EvictedCapacity})
 	}
-	return evict
+	shard.mutex.Unlock()
+	cLRU.notify(evictedEntries)
+	return len(evictedEntries) > 0
 }
 
-// GetFromCache returns false if item does not exist, if item exists it returns its value and moves it to the front of
-// the list
+// GetFromCache returns false if item does not exist, expired or is deleted, if item exists it returns its value and
+// moves it to the front of the list
 func (cLRU *CacheLRU) GetFromCache(key string) ([]byte, bool) {
-	if entry, ok := cLRU.items[key]; ok {
-		cLRU.listOfItems.MoveToFront(entry)
-		if string(entry.Value.(*lruEntry).value[0]) == "1" {
+	value, ok := cLRU.GetEntryFromCache(key)
+	if !ok {
+		return nil, false
+	}
+	return cachedValue(value)
+}
+
+// GetEntryFromCache returns false if item does not exist or expired, if item exists it returns its value, deleted or
+// not, and moves it to the front of the list
+func (cLRU *CacheLRU) GetEntryFromCache(key string) ([]byte, bool) {
+	shard := cLRU.shard(key)
+	shard.mutex.Lock()
+	if entry, ok := shard.items[key]; ok {
+		ent := entry.Value.(*lruEntry)
+		if ent.expired(time.Now()) {
+			shard.removeElement(entry)
+			shard.mutex.Unlock()
+			cLRU.notify([]evictedEntry{{ent, EvictedExpired}})
 			return nil, false
 		}
-		return entry.Value.(*lruEntry).value, true
+		shard.listOfItems.MoveToFront(entry)
+		shard.mutex.Unlock()
+		return ent.value, true
 	}
+	shard.mutex.Unlock()
 	return nil, false
 }
 
 // RemoveFromCache removes an item from the cache if it exists, if not, it returns false
 func (cLRU *CacheLRU) RemoveFromCache(key string) (present bool) {
-	if entry, ok := cLRU.items[key]; ok {
-		cLRU.removeElement(entry)
+	shard := cLRU.shard(key)
+	shard.mutex.Lock()
+	if entry, ok := shard.items[key]; ok {
+		shard.removeElement(entry)
+		shard.mutex.Unlock()
+		cLRU.notify([]evictedEntry{{entry.Value.(*lruEntry), EvictedRemoved}})
 		return true
 	}
+	shard.mutex.Unlock()
 	return false
 }
 
-// removeOldest internal function which removes the oldest item from cache (the one used least recently)
-func (cLRU *CacheLRU) removeOldest() {
-	entry := cLRU.listOfItems.Back()
+// removeOldest internal function which removes the oldest item from the shard (the one used least recently)
+func (shard *lruShard) removeOldest() *lruEntry {
+	entry := shard.listOfItems.Back()
 	if entry != nil {
-		cLRU.removeElement(entry)
+		shard.removeElement(entry)
+		return entry.Value.(*lruEntry)
 	}
+	return nil
 }
 
 // removeElement internal function which removes element from the list based on given element address
-func (cLRU *CacheLRU) removeElement(entry *list.Element) {
-	cLRU.listOfItems.Remove(entry)
+func (shard *lruShard) removeElement(entry *list.Element) {
+	shard.listOfItems.Remove(entry)
 	ent := entry.Value.(*lruEntry)
-	delete(cLRU.items, ent.key)
-
+	delete(shard.items, ent.key)
+	shard.usedBytes -= ent.bytes()
 }
 
-// PrintList prints the list of keys in their exact order
+// PrintList prints the list of keys in their exact order, shard by shard
 func (cLRU *CacheLRU) PrintList() {
-	for entry := cLRU.listOfItems.Front(); entry != nil; entry = entry.Next() {
-		keyS := entry.Value.(*lruEntry).key
-		fmt.Println(keyS)
+	for _, shard := range cLRU.shards {
+		shard.mutex.Lock()
+		for entry := shard.listOfItems.Front(); entry != nil; entry = entry.Next() {
+			keyS := entry.Value.(*lruEntry).key
+			fmt.Println(keyS)
+		}
+		shard.mutex.Unlock()
 	}
 }
 
 // PrintItems prints the keys and the values of all items in cache
 func (cLRU *CacheLRU) PrintItems() {
-	for key, val := range cLRU.items {
-		fmt.Println("Key: ", key, "Value: ", string(val.Value.(*lruEntry).value))
+	for _, shard := range cLRU.shards {
+		shard.mutex.Lock()
+		for key, val := range shard.items {
+			fmt.Println("Key: ", key, "Value: ", string(val.Value.(*lruEntry).value))
+		}
+		shard.mutex.Unlock()
 	}
 }
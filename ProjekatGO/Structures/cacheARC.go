@@ -0,0 +1,168 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+type arcEntry struct {
+	key   string
+	value []byte
+	owner *list.List
+}
+
+// CacheARC is an Adaptive Replacement Cache. t1 holds items seen once recently, t2 items seen at least twice, b1 and
+// b2 are ghost lists with keys evicted from t1 and t2, p is the adaptive target size of t1.
+type CacheARC struct {
+	mutex sync.Mutex
+	size  int
+	p     int
+	t1    *list.List
+	t2    *list.List
+	b1    *list.List
+	b2    *list.List
+	items map[string]*list.Element
+}
+
+// NewCacheARC creates a new Adaptive Replacement Cache.
+func NewCacheARC(size int) (*CacheARC, error) {
+	if size <= 0 {
+		return nil, errors.New("size of cache must be grater than 0")
+	}
+	return &CacheARC{
+		size:  size,
+		t1:    list.New(),
+		t2:    list.New(),
+		b1:    list.New(),
+		b2:    list.New(),
+		items: make(map[string]*list.Element),
+	}, nil
+}
+
+// moveTo moves the item to the front of the given list.
+func (c *CacheARC) moveTo(entry *list.Element, to *list.List) {
+	ent := entry.Value.(*arcEntry)
+	ent.owner.Remove(entry)
+	ent.owner = to
+	c.items[ent.key] = to.PushFront(ent)
+}
+
+// removeLast removes the last item of the given list from the cache.
+func (c *CacheARC) removeLast(from *list.List) {
+	entry := from.Back()
+	if entry != nil {
+		from.Remove(entry)
+		delete(c.items, entry.Value.(*arcEntry).key)
+	}
+}
+
+// replace evicts one item from t1 or t2 into its ghost list, depending on the target size p.
+func (c *CacheARC) replace(inB2 bool) {
+	if c.t1.Len() > 0 && (c.t1.Len() > c.p || (inB2 && c.t1.Len() == c.p) || c.t2.Len() == 0) {
+		entry := c.t1.Back()
+		entry.Value.(*arcEntry).value = nil
+		c.moveTo(entry, c.b1)
+	} else if c.t2.Len() > 0 {
+		entry := c.t2.Back()
+		entry.Value.(*arcEntry).value = nil
+		c.moveTo(entry, c.b2)
+	}
+}
+
+// AddToCache adds new item to the cache, if item already exists its value is changed and it is moved to t2
+func (c *CacheARC) AddToCache(key string, value []byte) (evicted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		ent := entry.Value.(*arcEntry)
+		switch ent.owner {
+		case c.t1, c.t2:
+			ent.value = value
+			c.moveTo(entry, c.t2)
+			return false
+		case c.b1:
+			c.p = minInt(c.size, c.p+maxInt(c.b2.Len()/c.b1.Len(), 1))
+		case c.b2:
+			c.p = maxInt(0, c.p-maxInt(c.b1.Len()/c.b2.Len(), 1))
+		}
+		if c.t1.Len()+c.t2.Len() >= c.size {
+			c.replace(ent.owner == c.b2)
+			evicted = true
+		}
+		ent.value = value
+		c.moveTo(entry, c.t2)
+		return evicted
+	}
+
+	if c.t1.Len()+c.t2.Len() >= c.size {
+		c.replace(false)
+		evicted = true
+	}
+	// Ghost lists never remember more than size keys together.
+	if c.b1.Len() > c.size-c.p {
+		c.removeLast(c.b1)
+	}
+	if c.b2.Len() > c.p {
+		c.removeLast(c.b2)
+	}
+	ent := &arcEntry{key: key, value: value, owner: c.t1}
+	c.items[key] = c.t1.PushFront(ent)
+	return evicted
+}
+
+// GetFromCache returns false if item does not exist or is deleted, if item exists it returns its value and moves it
+// to t2
+func (c *CacheARC) GetFromCache(key string) ([]byte, bool) {
+	value, ok := c.GetEntryFromCache(key)
+	if !ok {
+		return nil, false
+	}
+	return cachedValue(value)
+}
+
+// GetEntryFromCache returns false if item does not exist, if item exists it returns its value, deleted or not, and
+// moves it to t2
+func (c *CacheARC) GetEntryFromCache(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		ent := entry.Value.(*arcEntry)
+		if ent.owner == c.t1 || ent.owner == c.t2 {
+			c.moveTo(entry, c.t2)
+			return ent.value, true
+		}
+	}
+	return nil, false
+}
+
+// RemoveFromCache removes an item from the cache if it exists, if not, it returns false
+func (c *CacheARC) RemoveFromCache(key string) (present bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		ent := entry.Value.(*arcEntry)
+		ent.owner.Remove(entry)
+		delete(c.items, key)
+		return ent.owner == c.t1 || ent.owner == c.t2
+	}
+	return false
+}
+
+// minInt returns the smaller of two ints.
+func minInt(a int, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// maxInt returns the greater of two ints.
+func maxInt(a int, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,65 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// CachePolicies names of all cache eviction policies.
+var CachePolicies = []string{"lru", "lfu", "arc", "tinylfu"}
+
+// ReadTrace reads a key trace from the given file, one key per line.
+func ReadTrace(fileName string) ([]string, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(file)
+	var trace []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		key := strings.TrimSpace(scanner.Text())
+		if key != "" {
+			trace = append(trace, key)
+		}
+	}
+	return trace, scanner.Err()
+}
+
+// ReplayTrace looks up every key of the trace in the cache, adding it on a miss, and returns the hit rate.
+func ReplayTrace(cache Cache, trace []string) float64 {
+	if len(trace) == 0 {
+		return 0
+	}
+	hits := 0
+	for _, key := range trace {
+		if _, ok := cache.GetFromCache(key); ok {
+			hits++
+		} else {
+			cache.AddToCache(key, append([]byte("0"), key...))
+		}
+	}
+	return float64(hits) / float64(len(trace))
+}
+
+// CompareCachePolicies replays the trace on a cache of the given size for every policy and prints out the hit rates.
+// The LRU cache is not sharded, like the other policies.
+func CompareCachePolicies(trace []string, size int) map[string]float64 {
+	rates := make(map[string]float64)
+	for _, policy := range CachePolicies {
+		cache, err := NewCache(&Config{CacheSize: uint64(size), CacheShards: 1, CachePolicy: policy})
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		rates[policy] = ReplayTrace(cache, trace)
+		fmt.Printf("%-8s hit rate: %.4f\n", policy, rates[policy])
+	}
+	return rates
+}
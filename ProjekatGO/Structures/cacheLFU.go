@@ -0,0 +1,129 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+type lfuEntry struct {
+	key       string
+	value     []byte
+	frequency int
+}
+
+type CacheLFU struct {
+	mutex       sync.Mutex
+	size        int
+	minFreq     int
+	items       map[string]*list.Element
+	frequencies map[int]*list.List
+}
+
+// NewCacheLFU creates a new cache which evicts the least frequently used item, ties are broken by recency.
+func NewCacheLFU(size int) (*CacheLFU, error) {
+	if size <= 0 {
+		return nil, errors.New("size of cache must be grater than 0")
+	}
+	return &CacheLFU{
+		size:        size,
+		items:       make(map[string]*list.Element),
+		frequencies: make(map[int]*list.List),
+	}, nil
+}
+
+// touch moves the item to the list of the next frequency.
+func (c *CacheLFU) touch(entry *list.Element) *list.Element {
+	ent := entry.Value.(*lfuEntry)
+	c.frequencies[ent.frequency].Remove(entry)
+	if c.frequencies[ent.frequency].Len() == 0 {
+		delete(c.frequencies, ent.frequency)
+		if c.minFreq == ent.frequency {
+			c.minFreq++
+		}
+	}
+	ent.frequency++
+	return c.pushFront(ent)
+}
+
+// pushFront adds the item to the front of the list of its frequency.
+func (c *CacheLFU) pushFront(ent *lfuEntry) *list.Element {
+	if _, ok := c.frequencies[ent.frequency]; !ok {
+		c.frequencies[ent.frequency] = list.New()
+	}
+	entry := c.frequencies[ent.frequency].PushFront(ent)
+	c.items[ent.key] = entry
+	return entry
+}
+
+// AddToCache adds new item to the cache, if item already exists its value is changed and its frequency increased
+func (c *CacheLFU) AddToCache(key string, value []byte) (evicted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		entry.Value.(*lfuEntry).value = value
+		c.touch(entry)
+		return false
+	}
+	if len(c.items) >= c.size {
+		oldest := c.frequencies[c.minFreq].Back()
+		c.removeElement(oldest)
+		evicted = true
+	}
+	c.pushFront(&lfuEntry{key: key, value: value, frequency: 1})
+	c.minFreq = 1
+	return evicted
+}
+
+// GetFromCache returns false if item does not exist or is deleted, if item exists it returns its value and increases
+// its frequency
+func (c *CacheLFU) GetFromCache(key string) ([]byte, bool) {
+	value, ok := c.GetEntryFromCache(key)
+	if !ok {
+		return nil, false
+	}
+	return cachedValue(value)
+}
+
+// GetEntryFromCache returns false if item does not exist, if item exists it returns its value, deleted or not, and
+// increases its frequency
+func (c *CacheLFU) GetEntryFromCache(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		entry = c.touch(entry)
+		return entry.Value.(*lfuEntry).value, true
+	}
+	return nil, false
+}
+
+// RemoveFromCache removes an item from the cache if it exists, if not, it returns false
+func (c *CacheLFU) RemoveFromCache(key string) (present bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		c.removeElement(entry)
+		return true
+	}
+	return false
+}
+
+// removeElement removes the item from its frequency list and from the cache.
+func (c *CacheLFU) removeElement(entry *list.Element) {
+	ent := entry.Value.(*lfuEntry)
+	c.frequencies[ent.frequency].Remove(entry)
+	if c.frequencies[ent.frequency].Len() == 0 {
+		delete(c.frequencies, ent.frequency)
+	}
+	delete(c.items, ent.key)
+	if len(c.frequencies) > 0 && c.frequencies[c.minFreq] == nil {
+		c.minFreq = 0
+		for frequency := range c.frequencies {
+			if c.minFreq == 0 || frequency < c.minFreq {
+				c.minFreq = frequency
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+)
+
+const (
+	tinyLFUWindowPercent    = 1
+	tinyLFUProtectedPercent = 80
+	tinyLFUSampleFactor     = 10
+)
+
+type tinyLFUEntry struct {
+	key   string
+	value []byte
+	owner *list.List
+}
+
+// CacheTinyLFU is a W-TinyLFU cache. New items enter a small LRU window, items leaving the window are admitted into
+// the main segmented LRU (probation and protected) only if CountMinSketch estimates them as more frequent than the
+// item they would evict. The sketch is halved periodically so old frequencies fade out.
+type CacheTinyLFU struct {
+	mutex       sync.Mutex
+	windowSize  int
+	mainSize    int
+	protectSize int
+	window      *list.List
+	probation   *list.List
+	protected   *list.List
+	items       map[string]*list.Element
+	sketch      *CountMinSketch
+	samples     int
+	sampleLimit int
+}
+
+// NewCacheTinyLFU creates a new W-TinyLFU cache.
+func NewCacheTinyLFU(size int) (*CacheTinyLFU, error) {
+	if size <= 0 {
+		return nil, errors.New("size of cache must be grater than 0")
+	}
+	sketch, err := NewCMSWithEstimates(0.01, 0.01)
+	if err != nil {
+		return nil, err
+	}
+	windowSize := maxInt(1, size*tinyLFUWindowPercent/100)
+	mainSize := size - windowSize
+	return &CacheTinyLFU{
+		windowSize:  windowSize,
+		mainSize:    mainSize,
+		protectSize: mainSize * tinyLFUProtectedPercent / 100,
+		window:      list.New(),
+		probation:   list.New(),
+		protected:   list.New(),
+		items:       make(map[string]*list.Element),
+		sketch:      sketch,
+		sampleLimit: tinyLFUSampleFactor * size,
+	}, nil
+}
+
+// record counts one access of the key, after enough accesses all frequencies are halved.
+func (c *CacheTinyLFU) record(key string) {
+	c.sketch.Update(key)
+	c.samples++
+	if c.samples >= c.sampleLimit {
+		c.sketch.halve()
+		c.samples /= 2
+	}
+}
+
+// moveTo moves the item to the front of the given list.
+func (c *CacheTinyLFU) moveTo(entry *list.Element, to *list.List) *list.Element {
+	ent := entry.Value.(*tinyLFUEntry)
+	ent.owner.Remove(entry)
+	ent.owner = to
+	entry = to.PushFront(ent)
+	c.items[ent.key] = entry
+	return entry
+}
+
+// access moves the item after a hit, probation items are promoted to protected.
+func (c *CacheTinyLFU) access(entry *list.Element) {
+	ent := entry.Value.(*tinyLFUEntry)
+	switch ent.owner {
+	case c.window, c.protected:
+		ent.owner.MoveToFront(entry)
+	case c.probation:
+		c.moveTo(entry, c.protected)
+		if c.protected.Len() > c.protectSize {
+			c.moveTo(c.protected.Back(), c.probation)
+		}
+	}
+}
+
+// AddToCache adds new item to the window, if item already exists its value is changed
+func (c *CacheTinyLFU) AddToCache(key string, value []byte) (evicted bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.record(key)
+	if entry, ok := c.items[key]; ok {
+		entry.Value.(*tinyLFUEntry).value = value
+		c.access(entry)
+		return false
+	}
+	ent := &tinyLFUEntry{key: key, value: value, owner: c.window}
+	c.items[key] = c.window.PushFront(ent)
+	if c.window.Len() <= c.windowSize {
+		return false
+	}
+
+	candidate := c.window.Back()
+	if c.probation.Len()+c.protected.Len() < c.mainSize {
+		c.moveTo(candidate, c.probation)
+		return false
+	}
+	victim := c.probation.Back()
+	if victim == nil {
+		victim = c.protected.Back()
+	}
+	if victim == nil {
+		c.removeElement(candidate)
+		return true
+	}
+	candidateKey := candidate.Value.(*tinyLFUEntry).key
+	victimKey := victim.Value.(*tinyLFUEntry).key
+	if c.sketch.Estimate(candidateKey) > c.sketch.Estimate(victimKey) {
+		c.removeElement(victim)
+		c.moveTo(candidate, c.probation)
+	} else {
+		c.removeElement(candidate)
+	}
+	return true
+}
+
+// GetFromCache returns false if item does not exist or is deleted, if item exists it returns its value
+func (c *CacheTinyLFU) GetFromCache(key string) ([]byte, bool) {
+	value, ok := c.GetEntryFromCache(key)
+	if !ok {
+		return nil, false
+	}
+	return cachedValue(value)
+}
+
+// GetEntryFromCache returns false if item does not exist, if item exists it returns its value, deleted or not
+func (c *CacheTinyLFU) GetEntryFromCache(key string) ([]byte, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.record(key)
+	if entry, ok := c.items[key]; ok {
+		value := entry.Value.(*tinyLFUEntry).value
+		c.access(entry)
+		return value, true
+	}
+	return nil, false
+}
+
+// RemoveFromCache removes an item from the cache if it exists, if not, it returns false
+func (c *CacheTinyLFU) RemoveFromCache(key string) (present bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if entry, ok := c.items[key]; ok {
+		c.removeElement(entry)
+		return true
+	}
+	return false
+}
+
+// removeElement removes the item from its list and from the cache.
+func (c *CacheTinyLFU) removeElement(entry *list.Element) {
+	ent := entry.Value.(*tinyLFUEntry)
+	ent.owner.Remove(entry)
+	delete(c.items, ent.key)
+}
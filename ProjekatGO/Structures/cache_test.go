@@ -0,0 +1,109 @@
+package Structures
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cLRU, err := NewCacheLRU(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 16; i++ {
+		if cLRU.AddToCache(strconv.Itoa(i), []byte("0")) {
+			t.Fatal("item evicted before the cache is full")
+		}
+	}
+	cLRU.GetFromCache("0")
+	if !cLRU.AddToCache("16", []byte("0")) {
+		t.Fatal("no item evicted from a full cache")
+	}
+	if _, ok := cLRU.GetFromCache("1"); ok {
+		t.Error("least recently used item is not evicted")
+	}
+	if _, ok := cLRU.GetFromCache("0"); !ok {
+		t.Error("recently used item is evicted")
+	}
+}
+
+// benchmarkCache reads keys from the cache with 1 to 64 goroutines, adding the ones that are missing. The b.N reads are
+// split among the goroutines.
+func benchmarkCache(b *testing.B, cache Cache) {
+	keys := make([]string, 4096)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+	value := []byte("0value")
+	for goroutines := 1; goroutines <= 64; goroutines *= 2 {
+		b.Run(strconv.Itoa(goroutines), func(b *testing.B) {
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for g := 0; g < goroutines; g++ {
+				n := b.N / goroutines
+				if g < b.N%goroutines {
+					n++
+				}
+				wg.Add(1)
+				go func(g int, n int) {
+					defer wg.Done()
+					i := g * 7
+					for ; n > 0; n-- {
+						key := keys[i%len(keys)]
+						if _, ok := cache.GetFromCache(key); !ok {
+							cache.AddToCache(key, value)
+						}
+						i += 7
+					}
+				}(g, n)
+			}
+			wg.Wait()
+		})
+	}
+}
+
+func BenchmarkCacheLRU(b *testing.B) {
+	cLRU, err := NewCacheLRU(1024)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkCache(b, cLRU)
+}
+
+func BenchmarkCacheLRUSharded(b *testing.B) {
+	cLRU, err := NewShardedCacheLRU(1024, DefaultCacheShards)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkCache(b, cLRU)
+}
+
+func TestCacheLRUOnEvictWhileExpiring(t *testing.T) {
+	cLRU, err := NewCacheLRU(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cLRU.SetTTL(time.Millisecond)
+	cLRU.StartExpiry(time.Millisecond)
+	defer cLRU.StopExpiry()
+	expired := make(chan string, 1)
+	cLRU.OnEvict(func(key string, value []byte, reason EvictionReason) {
+		if reason == EvictedExpired {
+			select {
+			case expired <- key:
+			default:
+			}
+		}
+	})
+	cLRU.AddToCache("a", []byte("0"))
+	select {
+	case key := <-expired:
+		if key != "a" {
+			t.Error("unexpected key expired:", key)
+		}
+	case <-time.After(time.Second):
+		t.Error("expired item was not reported")
+	}
+}
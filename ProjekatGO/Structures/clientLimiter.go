@@ -0,0 +1,278 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultClient    = "default"
+	LimiterStatePath = "CMS_HLL/limiters.dat"
+)
+
+type Operation string
+
+const (
+	OpGet     Operation = "get"
+	OpPut     Operation = "put"
+	OpDelete  Operation = "delete"
+	OpScan    Operation = "scan"
+	OpCompact Operation = "compact"
+)
+
+// DefaultOperationCosts token cost of every operation, scan costs this much per returned row.
+var DefaultOperationCosts = map[Operation]int{
+	OpGet:     1,
+	OpPut:     2,
+	OpDelete:  2,
+	OpScan:    1,
+	OpCompact: 100,
+}
+
+type BucketConfig struct {
+	Capacity int    `yaml:"capacity"`
+	TimeRate int    `yaml:"time_rate"`
+	Limiter  string `yaml:"rate_limiter"`
+}
+
+// ClientLimiter keeps a named RateLimiter for every client. Clients without their own configuration get a limiter with
+// threshold, time rate and limiter type from the configuration file.
+type ClientLimiter struct {
+	mutex    sync.Mutex
+	config   *Config
+	buckets  map[string]RateLimiter
+	saved    map[string]limiterState
+	fileName string
+}
+
+type limiterState struct {
+	kind  string
+	state []byte
+}
+
+// NewClientLimiter returns a new ClientLimiter.
+func NewClientLimiter(config *Config) *ClientLimiter {
+	return &ClientLimiter{config: config, buckets: make(map[string]RateLimiter), saved: make(map[string]limiterState)}
+}
+
+// LoadClientLimiter returns a ClientLimiter with limiter states restored from the given file, time that passed since
+// they were saved is counted as usual. The state is saved to the same file after every operation, so restarting does
+// not refill the limiters. If the file does not exist or is invalid, all limiters start full.
+func LoadClientLimiter(config *Config, fileName string) *ClientLimiter {
+	cl := NewClientLimiter(config)
+	cl.fileName = fileName
+	data, err := os.ReadFile(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println(err)
+		}
+		return cl
+	}
+	saved, err := deserializeLimiterStates(data)
+	if err != nil {
+		fmt.Println(err)
+		return cl
+	}
+	cl.saved = saved
+	return cl
+}
+
+var errLimiterFile = errors.New("invalid rate limiter state file")
+
+// deserializeLimiterStates reads client name, limiter type and limiter state of every client.
+func deserializeLimiterStates(data []byte) (map[string]limiterState, error) {
+	saved := make(map[string]limiterState)
+	reader := bytes.NewReader(data)
+	read := func() ([]byte, error) {
+		sizeBytes := make([]byte, 8)
+		if _, err := io.ReadFull(reader, sizeBytes); err != nil {
+			return nil, err
+		}
+		size := binary.LittleEndian.Uint64(sizeBytes)
+		if size > uint64(reader.Len()) {
+			return nil, errLimiterFile
+		}
+		field := make([]byte, size)
+		_, err := io.ReadFull(reader, field)
+		return field, err
+	}
+	for reader.Len() > 0 {
+		var fields [3][]byte
+		for i := range fields {
+			field, err := read()
+			if err != nil {
+				return nil, errLimiterFile
+			}
+			fields[i] = field
+		}
+		saved[string(fields[0])] = limiterState{kind: string(fields[1]), state: fields[2]}
+	}
+	return saved, nil
+}
+
+// Serialize writes limiter states of all clients to the given file.
+func (cl *ClientLimiter) Serialize(fileName string) error {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.serialize(fileName)
+}
+
+func (cl *ClientLimiter) serialize(fileName string) error {
+	states := make(map[string]limiterState)
+	for client, state := range cl.saved {
+		states[client] = state
+	}
+	for client, b := range cl.buckets {
+		states[client] = limiterState{kind: limiterKind(b), state: b.State()}
+	}
+	var data []byte
+	write := func(field []byte) {
+		sizeBytes := make([]byte, 8)
+		binary.LittleEndian.PutUint64(sizeBytes, uint64(len(field)))
+		data = append(data, sizeBytes...)
+		data = append(data, field...)
+	}
+	for client, state := range states {
+		write([]byte(client))
+		write([]byte(state.kind))
+		write(state.state)
+	}
+	// The state is written to a temporary file which replaces the old one only once it is synced, a crash leaves
+	// either the old or the new state.
+	tmp := fileName + TempSuffix
+	file, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	if _, err = file.Write(data); err != nil {
+		_ = file.Close()
+		return err
+	}
+	if err = syncClose(file); err != nil {
+		return err
+	}
+	return os.Rename(tmp, fileName)
+}
+
+// save saves limiter states if the ClientLimiter was loaded from a file.
+func (cl *ClientLimiter) save() {
+	if cl.fileName == "" {
+		return
+	}
+	if err := cl.serialize(cl.fileName); err != nil {
+		fmt.Println(err)
+	}
+}
+
+// bucket returns the RateLimiter of the client, creating it on the first use. Unknown limiter types fall back to a
+// token bucket.
+func (cl *ClientLimiter) bucket(client string) RateLimiter {
+	if client == "" {
+		client = DefaultClient
+	}
+	if b, ok := cl.buckets[client]; ok {
+		return b
+	}
+	bc, ok := cl.config.ClientBuckets[client]
+	if !ok {
+		bc = BucketConfig{Capacity: int(cl.config.Threshold), TimeRate: cl.config.TimeRate}
+	}
+	if bc.Limiter == "" {
+		bc.Limiter = cl.config.LimiterType
+	}
+	b, err := NewRateLimiter(bc.Limiter, bc.Capacity, bc.TimeRate)
+	if err != nil {
+		fmt.Println(err)
+		b = NewBucketWithRate(bc.Capacity, bc.TimeRate)
+	}
+	// Saved state is used only if the limiter type did not change in the meantime.
+	if saved, ok := cl.saved[client]; ok {
+		if saved.kind == limiterKind(b) {
+			if err := b.SetState(saved.state); err != nil {
+				fmt.Println(err)
+			}
+		}
+		delete(cl.saved, client)
+	}
+	cl.buckets[client] = b
+	return b
+}
+
+// Cost returns the token cost of the operation, scan is charged per row. Costs are checked against bucket capacities
+// when the configuration is read, a scan of many rows never costs more than the capacity, so it only needs a full
+// bucket.
+func (cl *ClientLimiter) Cost(client string, op Operation, rows int) int {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	return cl.cost(cl.bucket(client), op, rows)
+}
+
+func (cl *ClientLimiter) cost(b RateLimiter, op Operation, rows int) int {
+	cost, ok := cl.config.OperationCosts[string(op)]
+	if !ok {
+		cost = DefaultOperationCosts[op]
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	cost *= rows
+	if cost > b.Capacity() {
+		cost = b.Capacity()
+	}
+	return cost
+}
+
+// Check returns how long the client has to wait until it has enough tokens for the operation, 0 if it has them now.
+// Tokens are not removed.
+func (cl *ClientLimiter) Check(client string, op Operation, rows int) time.Duration {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	b := cl.bucket(client)
+	return b.RetryAfter(cl.cost(b, op, rows))
+}
+
+// Charge removes the tokens of the operation from the client's limiter. It is used when the cost is known only after
+// the operation, like the number of rows of a scan.
+func (cl *ClientLimiter) Charge(client string, op Operation, rows int) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	b := cl.bucket(client)
+	b.Charge(cl.cost(b, op, rows))
+	cl.save()
+}
+
+// Allow removes the tokens of the operation if the client has them, otherwise it returns how long to wait.
+func (cl *ClientLimiter) Allow(client string, op Operation, rows int) (bool, time.Duration) {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	b := cl.bucket(client)
+	allowed, retryAfter := b.Allow(cl.cost(b, op, rows))
+	if allowed {
+		cl.save()
+	}
+	return allowed, retryAfter
+}
+
+// Info prints out tokens of every client.
+func (cl *ClientLimiter) Info() {
+	cl.mutex.Lock()
+	defer cl.mutex.Unlock()
+	var clients []string
+	for client := range cl.buckets {
+		clients = append(clients, client)
+	}
+	sort.Strings(clients)
+	for _, client := range clients {
+		b := cl.buckets[client]
+		fmt.Println(client, "tokens:", b.Tokens(), "/", b.Capacity())
+	}
+}
@@ -0,0 +1,159 @@
+package Structures
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// newLimiterConfig returns the default configuration with an admin client and rates slow enough not to refill during a
+// test.
+func newLimiterConfig() *Config {
+	config := defaultConfig()
+	config.TimeRate = 3600
+	config.ClientBuckets = map[string]BucketConfig{
+		"admin": {Capacity: 200, TimeRate: 3600, Limiter: LimiterGCRA},
+	}
+	return config
+}
+
+func TestClientLimiterSeparateBuckets(t *testing.T) {
+	cl := NewClientLimiter(newLimiterConfig())
+	if allowed, _ := cl.Allow("", OpCompact, 0); !allowed {
+		t.Fatal("compaction of the default client is not allowed with a full bucket")
+	}
+	if allowed, retryAfter := cl.Allow(DefaultClient, OpGet, 0); allowed || retryAfter <= 0 {
+		t.Errorf("get after a compaction emptied the bucket: allowed %v, retry after %v", allowed, retryAfter)
+	}
+	for i := 0; i < 2; i++ {
+		if allowed, _ := cl.Allow("admin", OpCompact, 0); !allowed {
+			t.Fatalf("compaction %d of admin is not allowed, it has capacity 200", i+1)
+		}
+	}
+	if allowed, _ := cl.Allow("admin", OpGet, 0); allowed {
+		t.Error("admin get is allowed with an empty bucket")
+	}
+	if allowed, _ := cl.Allow("guest", OpPut, 0); !allowed {
+		t.Error("a new client doesn't start with a full bucket")
+	}
+	if limiter := cl.bucket("admin"); limiterKind(limiter) != LimiterGCRA || limiter.Capacity() != 200 {
+		t.Errorf("admin has a %s limiter of capacity %d, expected gcra of 200", limiterKind(limiter),
+			limiter.Capacity())
+	}
+	if limiter := cl.bucket("guest"); limiterKind(limiter) != LimiterBucket || limiter.Capacity() != 100 {
+		t.Errorf("guest has a %s limiter of capacity %d, expected bucket of 100", limiterKind(limiter),
+			limiter.Capacity())
+	}
+}
+
+func TestClientLimiterCosts(t *testing.T) {
+	config := newLimiterConfig()
+	config.OperationCosts = map[string]int{"get": 3}
+	cl := NewClientLimiter(config)
+	costs := []struct {
+		client   string
+		op       Operation
+		rows     int
+		expected int
+	}{
+		{"", OpGet, 0, 3},
+		{"", OpPut, 0, 2},
+		{"", OpDelete, 0, 2},
+		{"", OpCompact, 0, 100},
+		{"", OpScan, 0, 1},
+		{"", OpScan, 30, 30},
+		{"", OpScan, 500, 100},
+		{"admin", OpScan, 500, 200},
+	}
+	for _, c := range costs {
+		if cost := cl.Cost(c.client, c.op, c.rows); cost != c.expected {
+			t.Errorf("%s of %d rows costs %d for %q, expected %d", c.op, c.rows, cost, c.client, c.expected)
+		}
+	}
+	cl.Charge("", OpScan, 40)
+	if tokens := cl.bucket("").Tokens(); tokens != 60 {
+		t.Errorf("%d tokens left after a scan of 40 rows, expected 60", tokens)
+	}
+}
+
+func TestNewConfigRejectsInvalidCosts(t *testing.T) {
+	newTestStore(t)
+	if err := os.Mkdir(DirectoryPath, 0777); err != nil {
+		t.Fatal(err)
+	}
+	data := "lsm_levels: 4\nlvl_tables: {1: 4, 2: 2, 3: 1}\nthreshold: 5\noperation_costs: {compact: 100}\n"
+	if err := os.WriteFile(DirectoryPath+"/configuration.yaml", []byte(data), 0666); err != nil {
+		t.Fatal(err)
+	}
+	if config, err := NewConfig("configuration.yaml"); err == nil {
+		t.Errorf("compaction costs more than the bucket capacity, got config %+v", config)
+	}
+	if _, err := NewConfig("missing.yaml"); err == nil {
+		t.Error("missing configuration file is not an error")
+	}
+}
+
+func TestShippedConfigIsValid(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = os.Chdir(wd)
+	}()
+	config, err := NewConfig("configuration.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cost := config.OperationCosts[string(OpCompact)]; cost != 100 {
+		t.Errorf("compaction costs %d tokens, expected 100", cost)
+	}
+}
+
+func TestClientLimiterStateSurvivesRestart(t *testing.T) {
+	newTestStore(t)
+	advance := fakeClock(t)
+	config := defaultConfig()
+	config.TimeRate = 100
+	config.ClientBuckets = map[string]BucketConfig{
+		"admin":  {Capacity: 200, TimeRate: 200, Limiter: LimiterGCRA},
+		"window": {Capacity: 100, TimeRate: 100, Limiter: LimiterWindow},
+	}
+	fileName := "limiters.dat"
+	cl := LoadClientLimiter(config, fileName)
+	for _, client := range []string{DefaultClient, "admin", "admin", "window"} {
+		if allowed, _ := cl.Allow(client, OpCompact, 0); !allowed {
+			t.Fatal("compaction is not allowed for", client)
+		}
+	}
+	if _, err := os.Stat(fileName + TempSuffix); err == nil {
+		t.Error("temporary state file is left after saving")
+	}
+	// The process is down for 30 seconds, buckets refill 1 token per second in the meantime.
+	advance(30 * time.Second)
+	cl = LoadClientLimiter(config, fileName)
+	for client, expected := range map[string]int{DefaultClient: 30, "admin": 30, "window": 0} {
+		if tokens := cl.bucket(client).Tokens(); tokens != expected {
+			t.Errorf("%s has %d tokens after the restart, expected %d", client, tokens, expected)
+		}
+	}
+	advance(70 * time.Second)
+	if tokens := cl.bucket("window").Tokens(); tokens != 100 {
+		t.Errorf("window has %d tokens once the compaction left it, expected 100", tokens)
+	}
+}
+
+func TestClientLimiterIgnoresTornState(t *testing.T) {
+	newTestStore(t)
+	fakeClock(t)
+	if err := os.WriteFile("limiters.dat", []byte{1, 2, 3}, 0666); err != nil {
+		t.Fatal(err)
+	}
+	cl := LoadClientLimiter(defaultConfig(), "limiters.dat")
+	if tokens := cl.bucket(DefaultClient).Tokens(); tokens != 100 {
+		t.Errorf("%d tokens with an invalid state file, expected a full bucket of 100", tokens)
+	}
+}
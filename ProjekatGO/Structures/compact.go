@@ -3,8 +3,11 @@ package Structures
 // Author: SV14/2020
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
@@ -12,31 +15,82 @@ import (
 	"strconv"
 )
 
-// Compact Performs a compaction between 2 SSTable-s.
-func Compact(s1 *SSTable, s2 *SSTable, level int) {
-	files1Data, errs1Data := os.OpenFile(s1.DirectoryPath+"/"+s1.DataPath, os.O_RDONLY|os.O_CREATE, 0666)
-	if errs1Data != nil {
-		log.Fatal(errs1Data)
-	}
+type CompactionReport struct {
+	BytesRead     uint64
+	BytesWritten  uint64
+	TablesRemoved []string
+}
+
+// add adds the other report to this one.
+func (r *CompactionReport) add(other CompactionReport) {
+	r.BytesRead += other.BytesRead
+	r.BytesWritten += other.BytesWritten
+	r.TablesRemoved = append(r.TablesRemoved, other.TablesRemoved...)
+}
 
-	files2Data, errs2Data := os.OpenFile(s2.DirectoryPath+"/"+s2.DataPath, os.O_RDONLY|os.O_CREATE, 0666)
-	if errs2Data != nil {
-		log.Fatal(errs2Data)
+// Info prints out CompactionReport data.
+func (r *CompactionReport) Info() {
+	fmt.Println("Bytes read:", r.BytesRead)
+	fmt.Println("Bytes written:", r.BytesWritten)
+	fmt.Println("Tables removed:", len(r.TablesRemoved))
+	for _, table := range r.TablesRemoved {
+		fmt.Println(table)
 	}
+}
 
-	bf1 := DeserializeFilter(s1.DirectoryPath + "/" + s1.FilterPath)
-	bf2 := DeserializeFilter(s2.DirectoryPath + "/" + s2.FilterPath)
+// Compact Performs a compaction between 2 SSTable-s of the level, s1 being the older one. See compactTables.
+func Compact(s1 *SSTable, s2 *SSTable, level int, store *Store) CompactionReport {
+	return compactTables([]*SSTable{s1, s2}, level, store)
+}
+
+// compactTables Merges the given SSTable-s of the level, ordered from the oldest to the newest, into a new SSTable on
+// the next level. A single SSTable is rewritten to the next level on its own. The new SSTable is written under a
+// temporary name and installed with a rename, the compaction log records which tables are authoritative in case of a
+// crash (see RecoverLSM). Every surviving record goes through the configured compaction filters and all writes go
+// through the I/O rate limiter with compaction priority.
+func compactTables(tables []*SSTable, level int, store *Store) CompactionReport {
+	report := CompactionReport{}
+	filesData := make([]*os.File, len(tables))
+	records := make([]Record, len(tables))
+	left := make([]bool, len(tables))
+	expected := 0
+	upperKey := ""
+	for i, s := range tables {
+		fileData, errData := os.OpenFile(s.DirectoryPath+"/"+s.DataPath, os.O_RDONLY|os.O_CREATE, 0666)
+		if errData != nil {
+			log.Fatal(errData)
+		}
+		filesData[i] = fileData
+		if bf := DeserializeFilter(s.DirectoryPath + "/" + s.FilterPath); bf != nil {
+			expected += int(bf.N())
+		}
+		if _, upper, ok := ReadBounds(s); ok && upper > upperKey {
+			upperKey = upper
+		}
+	}
+	older := olderTables(tables[0], level, store.Config)
+	// next reads the next record of the i-th table, left is false once the table is read to the end.
+	next := func(i int) {
+		key, value, tombstone, timestamp, n := ReadRecord(filesData[i])
+		records[i] = Record{Key: key, Value: value, Tombstone: tombstone != "0", Timestamp: timestamp}
+		left[i] = n != 0
+	}
+	for i := range tables {
+		next(i)
+	}
 
 	lsm := Lsm{}
 	s3 := SSTable{}
 	lsm.SetAttributes(&s3, level+1)
+	tablePath := s3.DirectoryPath
+	s3.DirectoryPath = tablePath + TempSuffix
 	errDir := os.Mkdir(s3.DirectoryPath, 0666)
 	if errDir != nil {
 		fmt.Println(errDir)
-		return
+		return report
 	}
 	files3Data, errs3Data := os.OpenFile(s3.DirectoryPath+"/"+s3.DataPath, os.O_WRONLY|os.O_CREATE, 0666)
-	if errs1Data != nil {
+	if errs3Data != nil {
 		log.Fatal(errs3Data)
 	}
 
@@ -50,164 +104,208 @@ func Compact(s1 *SSTable, s2 *SSTable, level int) {
 		log.Fatal(errs3Summary)
 	}
 
-	key1, value1, tombstone1, timestamp1, n1 := ReadRecord(files1Data)
-	key2, value2, tombstone2, timestamp2, n2 := ReadRecord(files2Data)
+	data3Writer := NewLimitedWriter(files3Data, store.IOLimiter, IOPriorityCompaction)
+	index3Writer := NewLimitedWriter(files3Index, store.IOLimiter, IOPriorityCompaction)
+	summary3Writer := NewLimitedWriter(files3Summary, store.IOLimiter, IOPriorityCompaction)
 
 	firstIteration := true
 
-	upper := ReturnUpperBound(s1.DirectoryPath+"/"+s1.SummaryPath, s2.DirectoryPath+"/"+s2.SummaryPath)
+	upper := makeLowerBound(upperKey)
 
 	offset := 0
 	offsetSummary := 0
 
-	bf3 := NewBloomFilter(int(bf1.N()+bf2.N()), 0.0001)
+	if expected == 0 {
+		expected = 1
+	}
+	bf3 := NewBloomFilter(expected, 0.0001)
 	var contents3 []Content
+	stats3 := newTableStats()
+
+	// Keys dropped or changed by filters, they are removed from the value cache once the new SSTable is installed.
+	var filtered []string
+
+	// write writes one surviving record to the new SSTable. Records dropped by filters are written as tombstones, so
+	// they hide older versions of the key. Tombstones of keys that no older table can hold are left out.
+	write := func(record Record) {
+		value := record.Value
+		if !record.Tombstone {
+			var keep bool
+			keep, value = applyFilters(store.Filters, level, record.Key, record.Value, record.Timestamp)
+			if !keep {
+				record.Tombstone = true
+				value = nil
+			}
+			if !keep || !bytes.Equal(value, record.Value) {
+				filtered = append(filtered, record.Key)
+			}
+		}
+		if record.Tombstone && !older.mayContain(record.Key) {
+			return
+		}
+		tombstone := "0"
+		if record.Tombstone {
+			tombstone = "1"
+		}
+		if firstIteration {
+			lower := makeLowerBound(record.Key)
+			insertHeader(summary3Writer, lower, upper)
+			firstIteration = false
+		}
+		valueTomb := append([]byte(tombstone), value...)
+		previous := offset
+		offset, offsetSummary = WriteRecord(data3Writer, index3Writer, summary3Writer, record.Key, valueTomb,
+			offset, offsetSummary, record.Timestamp)
+		stats3.add(record.Key, value, record.Tombstone, record.Timestamp, offset-previous)
+		bf3.Add(record.Key)
+		myContent := MyContent{key: record.Key, value: value}
+		contents3 = append(contents3, myContent)
+	}
 
+	// Records are merged by key, of all versions of a key the one with the greatest timestamp is written, and from the
+	// newest table if they were written at the same time.
 	for {
-		if n1 == 0 && n2 == 0 {
-			break
-		} else if n1 == 0 && n2 != 0 {
-			for {
-				if n2 == 0 {
-					break
-				}
-				if tombstone2 == "0" {
-					valueTomb := append([]byte(tombstone2), value2...)
-					offset, offsetSummary = WriteRecord(files3Data, files3Index, files3Summary, key2, valueTomb,
-						offset, offsetSummary, timestamp2)
-					bf3.Add(key2)
-					myContent := MyContent{key: key2, value: value2}
-					contents3 = append(contents3, myContent)
-				}
-				key2, value2, tombstone2, timestamp2, n2 = ReadRecord(files2Data)
+		newest := -1
+		for i := range tables {
+			if !left[i] {
+				continue
 			}
-			break
-		} else if n1 != 0 && n2 == 0 {
-			for {
-				if n1 == 0 {
-					break
-				}
-				if tombstone1 == "0" {
-					valueTomb := append([]byte(tombstone1), value1...)
-					offset, offsetSummary = WriteRecord(files3Data, files3Index, files3Summary, key1, valueTomb,
-						offset, offsetSummary, timestamp1)
-					bf3.Add(key1)
-					myContent := MyContent{key: key1, value: value1}
-					contents3 = append(contents3, myContent)
-				}
-				key1, value1, tombstone1, timestamp1, n1 = ReadRecord(files1Data)
+			if newest == -1 || records[i].Key < records[newest].Key ||
+				(records[i].Key == records[newest].Key && records[i].Timestamp >= records[newest].Timestamp) {
+				newest = i
 			}
+		}
+		if newest == -1 {
 			break
-		} else {
-			if key1 == key2 {
-				if timestamp1 > timestamp2 {
-					if tombstone1 == "0" {
-						if firstIteration {
-							lower := makeLowerBound(key1)
-							insertHeader(files3Summary, lower, upper)
-							firstIteration = false
-						}
-						valueTomb := append([]byte(tombstone1), value1...)
-						offset, offsetSummary = WriteRecord(files3Data, files3Index, files3Summary, key1, valueTomb,
-							offset, offsetSummary, timestamp1)
-						bf3.Add(key1)
-						myContent := MyContent{key: key1, value: value1}
-						contents3 = append(contents3, myContent)
-					}
-				} else {
-					if tombstone2 == "0" {
-						if firstIteration {
-							lower := makeLowerBound(key2)
-							insertHeader(files3Summary, lower, upper)
-							firstIteration = false
-						}
-						valueTomb := append([]byte(tombstone2), value2...)
-						offset, offsetSummary = WriteRecord(files3Data, files3Index, files3Summary, key2, valueTomb,
-							offset, offsetSummary, timestamp2)
-						bf3.Add(key2)
-						myContent := MyContent{key: key2, value: value2}
-						contents3 = append(contents3, myContent)
-					}
-				}
-				key1, value1, tombstone1, timestamp1, n1 = ReadRecord(files1Data)
-				key2, value2, tombstone2, timestamp2, n2 = ReadRecord(files2Data)
-			} else if key1 < key2 {
-				if tombstone1 == "0" {
-					if firstIteration {
-						lower := makeLowerBound(key1)
-						insertHeader(files3Summary, lower, upper)
-						firstIteration = false
-					}
-					valueTomb := append([]byte(tombstone1), value1...)
-					offset, offsetSummary = WriteRecord(files3Data, files3Index, files3Summary, key1, valueTomb,
-						offset, offsetSummary, timestamp1)
-					bf3.Add(key1)
-					myContent := MyContent{key: key1, value: value1}
-					contents3 = append(contents3, myContent)
-				}
-				key1, value1, tombstone1, timestamp1, n1 = ReadRecord(files1Data)
-			} else {
-				if tombstone2 == "0" {
-					if firstIteration {
-						lower := makeLowerBound(key2)
-						insertHeader(files3Summary, lower, upper)
-						firstIteration = false
-					}
-					valueTomb := append([]byte(tombstone2), value2...)
-					offset, offsetSummary = WriteRecord(files3Data, files3Index, files3Summary, key2, valueTomb,
-						offset, offsetSummary, timestamp2)
-					bf3.Add(key2)
-					myContent := MyContent{key: key2, value: value2}
-					contents3 = append(contents3, myContent)
-				}
-				key2, value2, tombstone2, timestamp2, n2 = ReadRecord(files2Data)
+		}
+		record := records[newest]
+		write(record)
+		for i := range tables {
+			if left[i] && records[i].Key == record.Key {
+				next(i)
 			}
 		}
-
 	}
 
 	files3Merkle, errs3Merkle := os.OpenFile(s3.DirectoryPath+"/"+s3.MerklePath, os.O_WRONLY|os.O_CREATE, 0666)
 	if errs3Merkle != nil {
 		log.Fatal(errs3Merkle)
 	}
-	merkleTree, _ := NewTree(contents3)
-	SerializeTree(merkleTree.root, files3Merkle, -1)
+	merkleTree, errTree := NewTreeWithHash(contents3, store.Config.MerkleAlgorithm())
+	if errTree == nil {
+		errWriteMerkle := merkleTree.Serialize(NewLimitedWriter(files3Merkle, store.IOLimiter, IOPriorityCompaction))
+		if errWriteMerkle != nil {
+			log.Fatal(errWriteMerkle)
+		}
+	}
 
-	bf3.Serialize(s3.DirectoryPath + "/" + s3.FilterPath)
-	err := files1Data.Close()
-	if err != nil {
-		return
+	files3Stats, errs3Stats := os.OpenFile(s3.DirectoryPath+"/"+s3.StatsPath, os.O_WRONLY|os.O_CREATE, 0666)
+	if errs3Stats != nil {
+		log.Fatal(errs3Stats)
 	}
-	err = files2Data.Close()
-	if err != nil {
-		return
+	errWriteStats := stats3.write(NewLimitedWriter(files3Stats, store.IOLimiter, IOPriorityCompaction))
+	if errWriteStats != nil {
+		log.Fatal(errWriteStats)
 	}
-	err = files3Data.Close()
-	if err != nil {
-		return
+
+	files3Filter, errs3Filter := os.OpenFile(s3.DirectoryPath+"/"+s3.FilterPath, os.O_WRONLY|os.O_CREATE, 0666)
+	if errs3Filter != nil {
+		log.Fatal(errs3Filter)
 	}
-	err = files3Index.Close()
-	if err != nil {
-		return
+	errWriteFilter := bf3.Write(NewLimitedWriter(files3Filter, store.IOLimiter, IOPriorityCompaction))
+	if errWriteFilter != nil {
+		log.Fatal(errWriteFilter)
 	}
-	err = files3Summary.Close()
-	if err != nil {
-		return
+	for _, fileData := range filesData {
+		err := fileData.Close()
+		if err != nil {
+			return report
+		}
 	}
-	err = files3Merkle.Close()
+	err := syncClose(files3Data, files3Index, files3Summary, files3Merkle, files3Stats, files3Filter)
 	if err != nil {
-		return
+		return report
 	}
+	crashPoint("write")
 
-	err = os.RemoveAll(s1.DirectoryPath)
+	var inputs []string
+	for _, s := range tables {
+		inputs = append(inputs, s.DirectoryPath)
+		report.BytesRead += tableSize(s.DirectoryPath)
+	}
+	err = writeCompactionLog(tablePath, inputs...)
 	if err != nil {
-		return
+		fmt.Println(err)
+		return report
 	}
-	err = os.RemoveAll(s2.DirectoryPath)
+	crashPoint("log")
+	err = os.Rename(s3.DirectoryPath, tablePath)
 	if err != nil {
-		return
+		fmt.Println(err)
+		return report
 	}
+	s3.DirectoryPath = tablePath
+	crashPoint("rename")
+	store.invalidate(filtered)
 
+	report.BytesWritten = tableSize(tablePath)
+	for _, input := range inputs {
+		err = os.RemoveAll(input)
+		if err != nil {
+			return report
+		}
+		crashPoint("remove")
+		if store.BlockCache != nil {
+			store.BlockCache.RemoveTable(input)
+		}
+		report.TablesRemoved = append(report.TablesRemoved, input)
+	}
+	_ = os.Remove(CompactionLogPath)
+	return report
+}
+
+type tableKeys struct {
+	lower  string
+	upper  string
+	filter *BloomFilter
+}
+
+type keyFilters []tableKeys
+
+// olderTables Returns key ranges and BloomFilter-s of SSTable-s that hold older data than the given table of the
+// level: the ones before it on the same level and all tables on the levels below.
+func olderTables(first *SSTable, level int, config *Config) keyFilters {
+	var older keyFilters
+	add := func(s *SSTable) {
+		lower, upper, ok := ReadBounds(s)
+		if !ok {
+			return
+		}
+		older = append(older, tableKeys{lower: lower, upper: upper,
+			filter: DeserializeFilter(s.DirectoryPath + "/" + s.FilterPath)})
+	}
+	for _, s := range levelTables(level) {
+		if s.DirectoryPath == first.DirectoryPath {
+			break
+		}
+		add(s)
+	}
+	for i := level + 1; i < int(config.LSMLevels); i++ {
+		for _, s := range levelTables(i) {
+			add(s)
+		}
+	}
+	return older
+}
+
+// mayContain checks if any of the tables may hold the key, tables without a filter are assumed to hold it.
+func (older keyFilters) mayContain(key string) bool {
+	for _, table := range older {
+		if key >= table.lower && key <= table.upper && (table.filter == nil || table.filter.Check(key)) {
+			return true
+		}
+	}
+	return false
 }
 
 // makeLowerBound Based on 2 summaries, returns the optimal lower bound.
@@ -225,7 +323,7 @@ func makeLowerBound(key string) []byte {
 }
 
 // insertHeader Inserts header into summary based on file.
-func insertHeader(fileSummary *os.File, lower []byte, upper []byte) {
+func insertHeader(fileSummary io.Writer, lower []byte, upper []byte) {
 	record := append(lower, upper...)
 	_, errWriteSummary := fileSummary.Write(record)
 	if errWriteSummary != nil {
@@ -234,34 +332,114 @@ func insertHeader(fileSummary *os.File, lower []byte, upper []byte) {
 
 }
 
-// CompactAll Calls Compact for each 2 SSTable-s on all levels expect the last one.
-func CompactAll(config *Config) {
-	for i := 1; i < int(config.LSMLevels)-1; i++ {
-		lvlPath := "LSM/C" + strconv.Itoa(i)
-		dirs, err := ioutil.ReadDir(lvlPath)
-		if err != nil {
-			_, err2 := fmt.Fprintln(os.Stderr, err)
-			if err2 != nil {
-				return
+// CompactAll Calls Compact for each 2 SSTable-s on all levels expect the last one. A level is compacted when it reaches
+// its table limit or when one of its tables has too many tombstones (see pickTables).
+func CompactAll(store *Store) CompactionReport {
+	report := CompactionReport{}
+	for i := 1; i < int(store.Config.LSMLevels)-1; i++ {
+		report.add(compactPairs(pickTables(levelTables(i), i, store.Config, false), i, store))
+	}
+	return report
+}
+
+// CompactLevel Calls Compact for each 2 SSTable-s on the given level, regardless of the level table limit.
+func CompactLevel(store *Store, level int) (CompactionReport, error) {
+	if level < 1 || level >= int(store.Config.LSMLevels)-1 {
+		return CompactionReport{}, errors.New("compaction: level " + strconv.Itoa(level) + " can't be compacted")
+	}
+	return compactPairs(pickTables(levelTables(level), level, store.Config, true), level, store), nil
+}
+
+// compactLevelFully Compacts all SSTable-s of the level to the next one, the newest one on its own if there is an odd
+// number, so the level is left empty.
+func compactLevelFully(store *Store, level int) CompactionReport {
+	tables := levelTables(level)
+	report := compactPairs(tables, level, store)
+	if len(tables)%2 == 1 {
+		report.add(compactTables(tables[len(tables)-1:], level, store))
+	}
+	return report
+}
+
+// CompactRange Compacts SSTable-s that overlap the key range [start, end], level by level, so the range ends up
+// compacted as deep as possible. All tables older than the newest overlapping one are compacted with them, a table left
+// on the level would hide newer versions of its keys moved to the next level. If there is an odd number, the newest
+// one is compacted to the next level on its own.
+func CompactRange(store *Store, start string, end string) (CompactionReport, error) {
+	report := CompactionReport{}
+	if start > end {
+		return report, errors.New("compaction: start of the range is greater than its end")
+	}
+	for i := 1; i < int(store.Config.LSMLevels)-1; i++ {
+		tables := levelTables(i)
+		last := -1
+		for j, s := range tables {
+			lower, upper, ok := ReadBounds(s)
+			if ok && lower <= end && upper >= start {
+				last = j
 			}
 		}
-		if len(dirs) < config.LvlTables[i] {
+		tables = tables[:last+1]
+		report.add(compactPairs(tables, i, store))
+		if len(tables)%2 == 1 {
+			report.add(compactTables(tables[len(tables)-1:], i, store))
+		}
+	}
+	return report, nil
+}
+
+// pickTables Picks SSTable-s of the level to compact based on their statistics. Tables stay ordered from the oldest to
+// the newest, if there is an odd number the newest one is left on the level, so only adjacent tables are compacted.
+// Returns nil if the level doesn't need compaction: it is below its table limit and no table has tombstone ratio over
+// the configured one, unless forced.
+func pickTables(tables []*SSTable, level int, config *Config, force bool) []*SSTable {
+	for _, s := range tables {
+		stats, err := ReadTableStats(s)
+		if err != nil {
+			fmt.Println(err)
 			continue
 		}
+		if config.CompactionTombRatio > 0 && stats.TombstoneRatio() >= config.CompactionTombRatio {
+			force = true
+		}
+	}
+	if !force && len(tables) < config.LvlTables[level] {
+		return nil
+	}
+	return tables[:len(tables)-len(tables)%2]
+}
+
+// compactPairs Calls Compact for each 2 of the given SSTable-s, which are adjacent on the level and ordered from the
+// oldest to the newest. The last one is left out if there is an odd number.
+func compactPairs(tables []*SSTable, level int, store *Store) CompactionReport {
+	report := CompactionReport{}
+	for j := 0; j+1 < len(tables); j += 2 {
+		report.add(Compact(tables[j], tables[j+1], level, store))
+	}
+	return report
+}
 
-		sort.Slice(dirs, func(i, j int) bool {
-			return dirs[i].ModTime().Before(dirs[j].ModTime())
-		})
+// levelTables Returns all SSTable-s on the given level, from the oldest to the newest.
+func levelTables(level int) []*SSTable {
+	return levelTablesAt("LSM/C" + strconv.Itoa(level))
+}
 
-		for j := 0; j < len(dirs); j += 2 {
-			if j+1 == len(dirs) {
-				break
-			}
-			s1 := tableFPath(lvlPath + "/" + dirs[j].Name())
-			s2 := tableFPath(lvlPath + "/" + dirs[j+1].Name())
-			Compact(s1, s2, i)
-		}
+// levelTablesAt Returns all SSTable-s in the given level directory, from the oldest to the newest.
+func levelTablesAt(lvlPath string) []*SSTable {
+	dirs, err := ioutil.ReadDir(lvlPath)
+	if err != nil {
+		_, _ = fmt.Fprintln(os.Stderr, err)
+		return nil
 	}
+	dirs = tableDirs(dirs)
+	sort.Slice(dirs, func(i, j int) bool {
+		return dirs[i].ModTime().Before(dirs[j].ModTime())
+	})
+	var tables []*SSTable
+	for _, dir := range dirs {
+		tables = append(tables, tableFPath(lvlPath+"/"+dir.Name()))
+	}
+	return tables
 }
 
 // tableFPath Returns SSTable based on given path.
@@ -272,6 +450,18 @@ func tableFPath(path string) *SSTable {
 		IndexPath:     "sstable-index.dat",
 		SummaryPath:   "sstable-summary.dat",
 		FilterPath:    "sstable-filter.dat",
-		MerklePath:    "metadata.dat"}
+		MerklePath:    "metadata.dat",
+		StatsPath:     "sstable-stats.dat"}
 	return &s
 }
+
+// tableDirs Returns only the installed SSTable directories from the given level listing.
+func tableDirs(dirs []os.FileInfo) []os.FileInfo {
+	var tables []os.FileInfo
+	for _, dir := range dirs {
+		if dir.IsDir() && isTableDir(dir.Name()) {
+			tables = append(tables, dir)
+		}
+	}
+	return tables
+}
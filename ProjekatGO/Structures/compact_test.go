@@ -0,0 +1,48 @@
+package Structures
+
+import "testing"
+
+func TestCompactKeepsNewestVersion(t *testing.T) {
+	store := newTestStore(t)
+	flushTable(t, store, "a", "1", "b", "1")
+	flushTable(t, store, "c", "1", "d", "1")
+	flushTable(t, store, "e", "1", "f", "1")
+	flushTable(t, store, "g", "1", "x", "old")
+	flushTable(t, store, "b", "", "x", "new")
+	CompactAll(store)
+	if value, ok := lookup(store, "x"); !ok || value != "new" {
+		t.Errorf("x = %q, %v, expected new", value, ok)
+	}
+	if _, ok := lookup(store, "b"); ok {
+		t.Error("deleted key b is found")
+	}
+	flushTable(t, store, "h", "1")
+	flushTable(t, store, "i", "1")
+	flushTable(t, store, "j", "1")
+	CompactAll(store)
+	if _, ok := lookup(store, "b"); ok {
+		t.Error("deleted key b is found after its tombstone was compacted")
+	}
+	if value, ok := lookup(store, "x"); !ok || value != "new" {
+		t.Errorf("x = %q, %v, expected new", value, ok)
+	}
+}
+
+func TestCompactRangeSingleTable(t *testing.T) {
+	store := newTestStore(t)
+	flushTable(t, store, "a", "1", "x", "old")
+	flushTable(t, store, "m", "1")
+	flushTable(t, store, "b", "2", "x", "new")
+	flushTable(t, store, "z", "9")
+	if _, err := CompactRange(store, "a", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if LevelTableCount(1) != 1 {
+		t.Errorf("%d tables on the first level, expected only the one outside the range", LevelTableCount(1))
+	}
+	for key, expected := range map[string]string{"a": "1", "b": "2", "m": "1", "x": "new", "z": "9"} {
+		if value, ok := lookup(store, key); !ok || value != expected {
+			t.Errorf("%s = %q, %v, expected %s", key, value, ok, expected)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"strings"
+	"time"
+)
+
+type FilterDecision int
+
+const (
+	FilterKeep FilterDecision = iota
+	FilterDrop
+	FilterChange
+)
+
+// CompactionFilter is called by Compact for every record that survives the merge. It decides if the record is kept,
+// dropped or written with a new value (returned together with FilterChange). A dropped record is replaced by a
+// tombstone while older tables may hold the key. Tables on the last level are never compacted, so records that reached
+// it are not filtered any more.
+type CompactionFilter interface {
+	Filter(level int, key string, value []byte, timestamp int64) (FilterDecision, []byte)
+}
+
+type TTLFilter struct {
+	retention time.Duration
+	prefix    string
+}
+
+// NewTTLFilter returns a new TTLFilter which drops records with the given key prefix ("" for all keys) that were
+// written more than retention ago.
+func NewTTLFilter(retention time.Duration, prefix string) *TTLFilter {
+	return &TTLFilter{retention: retention, prefix: prefix}
+}
+
+// Filter drops the record if it expired. Keys reserved for the store never expire.
+func (f *TTLFilter) Filter(_ int, key string, _ []byte, timestamp int64) (FilterDecision, []byte) {
+	if !strings.HasPrefix(key, f.prefix) || IsSystemKey(key) {
+		return FilterKeep, nil
+	}
+	if time.Unix(timestamp, 0).Add(f.retention).Before(time.Now()) {
+		return FilterDrop, nil
+	}
+	return FilterKeep, nil
+}
+
+// applyFilters runs the record through all filters, returns false if the record is dropped and the value to write
+// otherwise.
+func applyFilters(filters []CompactionFilter, level int, key string, value []byte, timestamp int64) (bool, []byte) {
+	for _, filter := range filters {
+		decision, newValue := filter.Filter(level, key, value, timestamp)
+		if decision == FilterDrop {
+			return false, nil
+		} else if decision == FilterChange {
+			value = newValue
+		}
+	}
+	return true, value
+}
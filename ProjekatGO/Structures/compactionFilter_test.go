@@ -0,0 +1,60 @@
+package Structures
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTTLFilterKeepsFreshRecords(t *testing.T) {
+	store := newTestStore(t)
+	store.AddCompactionFilter(NewTTLFilter(time.Hour, ""))
+	flushTable(t, store, "a", "1")
+	flushTable(t, store, "b", "2")
+	CompactLevel(store, 1)
+	for key, expected := range map[string]string{"a": "1", "b": "2"} {
+		if value, ok := lookup(store, key); !ok || value != expected {
+			t.Errorf("%s = %q, %v, expected %s", key, value, ok, expected)
+		}
+	}
+}
+
+func TestTTLFilterDropsExpiredRecords(t *testing.T) {
+	filter := NewTTLFilter(time.Hour, "session:")
+	expired := time.Now().Add(-2 * time.Hour).Unix()
+	if decision, _ := filter.Filter(1, "session:1", nil, expired); decision != FilterDrop {
+		t.Error("expired record is kept")
+	}
+	if decision, _ := filter.Filter(1, "user:1", nil, expired); decision != FilterKeep {
+		t.Error("record without the prefix is dropped")
+	}
+	if decision, _ := filter.Filter(1, "session:2", nil, time.Now().Unix()); decision != FilterKeep {
+		t.Error("fresh record is dropped")
+	}
+}
+
+// dropFilter drops records with the given key.
+type dropFilter string
+
+func (f dropFilter) Filter(_ int, key string, _ []byte, _ int64) (FilterDecision, []byte) {
+	if key == string(f) {
+		return FilterDrop, nil
+	}
+	return FilterKeep, nil
+}
+
+func TestFilterDropHidesOlderVersion(t *testing.T) {
+	store := newTestStore(t)
+	formTable(t, store, "LSM/C2", "session:1", "old")
+	flushTable(t, store, "session:1", "new")
+	flushTable(t, store, "z", "1")
+	store.AddCompactionFilter(dropFilter("session:1"))
+	if _, err := CompactLevel(store, 1); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok := lookup(store, "session:1"); ok {
+		t.Errorf("dropped key session:1 = %q, the older version is found", value)
+	}
+	if value, ok := lookup(store, "z"); !ok || value != "1" {
+		t.Errorf("z = %q, %v, expected 1", value, ok)
+	}
+}
@@ -4,7 +4,6 @@ import (
 	"fmt"
 	"gopkg.in/yaml.v2"
 	"io/ioutil"
-	"log"
 )
 
 const (
@@ -12,47 +11,158 @@ const (
 )
 
 type Config struct {
-	WalSize      uint64      `yaml:"wal_size"`
-	MemtableSize uint64      `yaml:"memtable_size"`
-	LSMLevels    uint64      `yaml:"lsm_levels"`
-	CacheSize    uint64      `yaml:"cache_size"`
-	Threshold    uint8       `yaml:"threshold"`
-	TimeRate     int         `yaml:"time_rate"`
-	LvlTables    map[int]int `yaml:"lvl_tables"`
+	WalSize              uint64                  `yaml:"wal_size"`
+	MemtableSize         uint64                  `yaml:"memtable_size"`
+	LSMLevels            uint64                  `yaml:"lsm_levels"`
+	CacheSize            uint64                  `yaml:"cache_size"`
+	CacheShards          int                     `yaml:"cache_shards"`
+	CachePolicy          string                  `yaml:"cache_policy"`
+	CacheSizeBytes       int64                   `yaml:"cache_size_bytes"`
+	CacheTTL             int64                   `yaml:"cache_ttl"`
+	CacheExpiryInterval  int64                   `yaml:"cache_expiry_interval"`
+	Threshold            uint8                   `yaml:"threshold"`
+	TimeRate             int                     `yaml:"time_rate"`
+	LimiterType          string                  `yaml:"rate_limiter"`
+	ClientBuckets        map[string]BucketConfig `yaml:"client_buckets"`
+	OperationCosts       map[string]int          `yaml:"operation_costs"`
+	LvlTables            map[int]int             `yaml:"lvl_tables"`
+	L1SlowdownTables     int                     `yaml:"l1_slowdown_tables"`
+	L1StopTables         int                     `yaml:"l1_stop_tables"`
+	PendingSlowdownBytes uint64                  `yaml:"pending_compaction_slowdown_bytes"`
+	PendingStopBytes     uint64                  `yaml:"pending_compaction_stop_bytes"`
+	SlowdownDelay        int                     `yaml:"slowdown_delay"`
+	CompactionTTL        int64                   `yaml:"compaction_ttl"`
+	CompactionTTLPrefix  string                  `yaml:"compaction_ttl_prefix"`
+	IORate               int64                   `yaml:"io_rate_bytes"`
+	BlockCacheSize       int64                   `yaml:"block_cache_size_bytes"`
+	PinIndexBlocks       bool                    `yaml:"block_cache_pin_index"`
+	NegativeCacheSize    int                     `yaml:"negative_cache_size"`
+	TopKSize             int                     `yaml:"top_k_size"`
+	CMSType              string                  `yaml:"cms_type"`
+	CMSWindow            int64                   `yaml:"cms_window"`
+	CMSWindowSlots       int                     `yaml:"cms_window_slots"`
+	CMSHalfLife          int64                   `yaml:"cms_half_life"`
+	CMSConservative      bool                    `yaml:"cms_conservative"`
+	HLLPrecision         uint32                  `yaml:"hll_precision"`
+	HLLPrefixes          []string                `yaml:"hll_prefixes"`
+	SketchCheckpointOps  int                     `yaml:"sketch_checkpoint_ops"`
+	SketchCheckpointTime int64                   `yaml:"sketch_checkpoint_interval"`
+	CompactionTombRatio  float64                 `yaml:"compaction_tombstone_ratio"`
+	MerkleBuckets        int                     `yaml:"merkle_buckets"`
+	MerkleHash           string                  `yaml:"merkle_hash"`
 }
 
-// NewConfig returns a new Config from given configuration file.
-func NewConfig(fileName string) (config *Config) {
+// NewConfig returns a new Config from given configuration file, or an error if the file can't be read or the
+// configuration is invalid.
+func NewConfig(fileName string) (*Config, error) {
 	b, _ := IsEmptyDir(DirectoryPath)
 	if b {
-		return defaultConfig()
+		return defaultConfig(), nil
 	}
 
 	configData, err := ioutil.ReadFile(DirectoryPath + "/" + fileName)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	err1 := yaml.Unmarshal(configData, &config)
-	if err1 != nil {
-		return nil
+	var config *Config
+	err = yaml.Unmarshal(configData, &config)
+	if err != nil {
+		return nil, err
+	}
+	if config == nil {
+		return nil, fmt.Errorf("config: %s is empty", fileName)
 	}
-	_, err2 := yaml.Marshal(config)
-	if err2 != nil {
-		log.Fatal(err2)
+	err = config.validate()
+	if err != nil {
+		return nil, err
 	}
-	return
+	return config, nil
 }
 
 // defaultConfig creates default Config.
 func defaultConfig() (config *Config) {
 	return &Config{
-		WalSize:      5,
-		MemtableSize: 10,
-		LSMLevels:    4,
-		CacheSize:    5,
-		Threshold:    5,
-		TimeRate:     30,
-		LvlTables:    map[int]int{1: 4, 2: 2, 3: 1}}
+		WalSize:              5,
+		MemtableSize:         10,
+		LSMLevels:            4,
+		CacheSize:            5,
+		CacheShards:          DefaultCacheShards,
+		CachePolicy:          "lru",
+		CacheSizeBytes:       0,
+		CacheTTL:             0,
+		CacheExpiryInterval:  60,
+		Threshold:            100,
+		TimeRate:             30,
+		LimiterType:          LimiterBucket,
+		OperationCosts:       map[string]int{"get": 1, "put": 2, "delete": 2, "scan": 1, "compact": 100},
+		LvlTables:            map[int]int{1: 4, 2: 2, 3: 1},
+		L1SlowdownTables:     8,
+		L1StopTables:         12,
+		PendingSlowdownBytes: 64 << 20,
+		PendingStopBytes:     256 << 20,
+		SlowdownDelay:        100,
+		CompactionTTL:        0,
+		IORate:               16 << 20,
+		BlockCacheSize:       8 << 20,
+		PinIndexBlocks:       true,
+		NegativeCacheSize:    1000,
+		TopKSize:             100,
+		CMSType:              SketchPlain,
+		CMSWindow:            3600,
+		CMSWindowSlots:       6,
+		CMSHalfLife:          3600,
+		CMSConservative:      false,
+		HLLPrecision:         DefaultHLLPrecision,
+		HLLPrefixes:          []string{},
+		SketchCheckpointOps:  50,
+		SketchCheckpointTime: 60,
+		CompactionTombRatio:  0.5,
+		MerkleBuckets:        DefaultStoreBuckets,
+		MerkleHash:           DefaultMerkleHash.String()}
+}
+
+// validate checks that every operation costs at most the capacity of every token bucket, an operation that costs more
+// could never be done. Levels which are compacted must allow at least 2 tables and L1 triggers must be off (0) or at
+// least 2, otherwise a level would stay over its limit with the table compaction leaves on it.
+func (c *Config) validate() error {
+	for level := 1; level < int(c.LSMLevels)-1; level++ {
+		if c.LvlTables[level] < 2 {
+			return fmt.Errorf("config: level %d allows %d tables, it must allow at least 2", level,
+				c.LvlTables[level])
+		}
+	}
+	if c.L1SlowdownTables == 1 || c.L1StopTables == 1 {
+		return fmt.Errorf("config: L1 slowdown and stop triggers must be 0 or at least 2")
+	}
+	if _, err := ParseHashAlgorithm(c.MerkleHash); err != nil {
+		return err
+	}
+	capacities := map[string]int{DefaultClient: int(c.Threshold)}
+	for client, bucket := range c.ClientBuckets {
+		capacities[client] = bucket.Capacity
+	}
+	for op, defaultCost := range DefaultOperationCosts {
+		cost, ok := c.OperationCosts[string(op)]
+		if !ok {
+			cost = defaultCost
+		}
+		for client, capacity := range capacities {
+			if cost > capacity {
+				return fmt.Errorf("config: %s costs %d tokens, more than the capacity %d of client %s", op, cost,
+					capacity, client)
+			}
+		}
+	}
+	return nil
+}
+
+// MerkleAlgorithm returns the hash algorithm of new Merkle trees, the default one if the configured one is unknown.
+func (c *Config) MerkleAlgorithm() HashAlgorithm {
+	algorithm, err := ParseHashAlgorithm(c.MerkleHash)
+	if err != nil {
+		return DefaultMerkleHash
+	}
+	return algorithm
 }
 
 // Info prints Config data.
@@ -61,6 +171,35 @@ func (c *Config) Info() {
 	fmt.Println("MemtableSize: ", c.MemtableSize)
 	fmt.Println("LSMLevels: ", c.LSMLevels)
 	fmt.Println("CacheSize: ", c.CacheSize)
+	fmt.Println("CacheShards: ", c.CacheShards)
+	fmt.Println("CachePolicy: ", c.CachePolicy)
+	fmt.Println("CacheSizeBytes: ", c.CacheSizeBytes)
+	fmt.Println("CacheTTL: ", c.CacheTTL)
+	fmt.Println("CacheExpiryInterval: ", c.CacheExpiryInterval)
 	fmt.Println("Threshold: ", c.Threshold)
 	fmt.Println("LvlTables: ", c.LvlTables)
+	fmt.Println("L1SlowdownTables: ", c.L1SlowdownTables)
+	fmt.Println("L1StopTables: ", c.L1StopTables)
+	fmt.Println("PendingSlowdownBytes: ", c.PendingSlowdownBytes)
+	fmt.Println("PendingStopBytes: ", c.PendingStopBytes)
+	fmt.Println("SlowdownDelay: ", c.SlowdownDelay)
+	fmt.Println("CompactionTTL: ", c.CompactionTTL)
+	fmt.Println("CompactionTTLPrefix: ", c.CompactionTTLPrefix)
+	fmt.Println("IORate: ", c.IORate)
+	fmt.Println("BlockCacheSize: ", c.BlockCacheSize)
+	fmt.Println("PinIndexBlocks: ", c.PinIndexBlocks)
+	fmt.Println("NegativeCacheSize: ", c.NegativeCacheSize)
+	fmt.Println("TopKSize: ", c.TopKSize)
+	fmt.Println("CMSType: ", c.CMSType)
+	fmt.Println("CMSWindow: ", c.CMSWindow)
+	fmt.Println("CMSWindowSlots: ", c.CMSWindowSlots)
+	fmt.Println("CMSHalfLife: ", c.CMSHalfLife)
+	fmt.Println("CMSConservative: ", c.CMSConservative)
+	fmt.Println("HLLPrecision: ", c.HLLPrecision)
+	fmt.Println("HLLPrefixes: ", c.HLLPrefixes)
+	fmt.Println("SketchCheckpointOps: ", c.SketchCheckpointOps)
+	fmt.Println("SketchCheckpointTime: ", c.SketchCheckpointTime)
+	fmt.Println("CompactionTombRatio: ", c.CompactionTombRatio)
+	fmt.Println("MerkleBuckets: ", c.MerkleBuckets)
+	fmt.Println("MerkleHash: ", c.MerkleHash)
 }
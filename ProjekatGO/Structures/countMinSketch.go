@@ -13,10 +13,11 @@ import (
 )
 
 type CountMinSketch struct {
-	d      uint32
-	w      uint32
-	table  [][]uint64
-	hashes []hash.Hash32
+	d            uint32
+	w            uint32
+	table        [][]uint64
+	hashes       []hash.Hash32
+	conservative bool
 }
 
 // NewCMS Initializing a CMS with d as number of hash functions and w as number of columns
@@ -76,14 +77,19 @@ func CreateCmsHashFunctions(d uint32) []hash.Hash32 {
 
 // locations Generates Locations for a given key (this function should not be visible outside this module).
 func (s *CountMinSketch) locations(key []byte) (locations []uint32) {
-	locations = make([]uint32, s.d)
-	for i, hashFunction := range s.hashes {
+	return cmsLocations(s.hashes, s.w, key)
+}
+
+// cmsLocations Generates a column in every row for a given key.
+func cmsLocations(hashes []hash.Hash32, w uint32, key []byte) (locations []uint32) {
+	locations = make([]uint32, len(hashes))
+	for i, hashFunction := range hashes {
 		_, err := hashFunction.Write(key)
 		if err != nil {
 			return nil
 		}
 		hashValue := hashFunction.Sum32()
-		column := hashValue % s.w
+		column := hashValue % w
 		locations[i] = column
 		hashFunction.Reset()
 	}
@@ -92,15 +98,51 @@ func (s *CountMinSketch) locations(key []byte) (locations []uint32) {
 
 // Update updates a table in CMS for given key.
 func (s *CountMinSketch) Update(key string) {
-	for row, column := range s.locations([]byte(key)) {
-		s.table[row][column] += 1
+	s.UpdateBy(key, 1)
+}
+
+// UpdateBy counts n occurrences of the given key. In conservative mode only the counters below the new estimate are
+// raised, which overestimates less than incrementing every row.
+func (s *CountMinSketch) UpdateBy(key string, n uint64) {
+	locations := s.locations([]byte(key))
+	if s.conservative {
+		target := s.estimate(locations) + n
+		for row, column := range locations {
+			if s.table[row][column] < target {
+				s.table[row][column] = target
+			}
+		}
+		return
+	}
+	for row, column := range locations {
+		s.table[row][column] += n
 	}
 }
 
+// SetConservative turns conservative update on or off.
+func (s *CountMinSketch) SetConservative(conservative bool) {
+	s.conservative = conservative
+}
+
+// Merge adds the counts of another CMS with the same d and w, so sketches built on separate nodes or time slices can
+// be combined.
+func (s *CountMinSketch) Merge(other *CountMinSketch) error {
+	if s.d != other.d || s.w != other.w {
+		return errors.New("countminsketch: sketches have different d or w")
+	}
+	s.add(other)
+	return nil
+}
+
 // Estimate estimates the frequency of a key in our CMS.
 func (s *CountMinSketch) Estimate(key string) uint64 {
+	return s.estimate(s.locations([]byte(key)))
+}
+
+// estimate returns the smallest counter at the given locations.
+func (s *CountMinSketch) estimate(locations []uint32) uint64 {
 	var min uint64
-	for row, column := range s.locations([]byte(key)) {
+	for row, column := range locations {
 		if row == 0 || s.table[row][column] < min {
 			min = s.table[row][column]
 		}
@@ -108,13 +150,34 @@ func (s *CountMinSketch) Estimate(key string) uint64 {
 	return min
 }
 
+// halve halves all counters, used to age frequencies.
+func (s *CountMinSketch) halve() {
+	for _, row := range s.table {
+		for i := range row {
+			row[i] /= 2
+		}
+	}
+}
+
 // SerializeCMS Serializes CMS structure to the given file.
 func (s *CountMinSketch) SerializeCMS(fileName string) {
-	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0666)
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
+	_, err = file.Write(s.bytes())
+	if err != nil {
+		return
+	}
+	err = file.Close()
+	if err != nil {
+		return
+	}
+}
+
+// bytes returns d, w and the table of CMS.
+func (s *CountMinSketch) bytes() []byte {
 	bytes := make([]byte, 8)
 	binary.LittleEndian.PutUint32(bytes[:4], s.d)
 	binary.LittleEndian.PutUint32(bytes[4:8], s.w)
@@ -125,14 +188,7 @@ func (s *CountMinSketch) SerializeCMS(fileName string) {
 			bytes = append(bytes, bytesEl...)
 		}
 	}
-	_, err = file.Write(bytes)
-	if err != nil {
-		return
-	}
-	err = file.Close()
-	if err != nil {
-		return
-	}
+	return bytes
 }
 
 // DeserializeCMS Deserializes CMS structure from the given file.
@@ -142,9 +198,29 @@ func DeserializeCMS(fileName string) *CountMinSketch {
 		fmt.Println(err)
 		return nil
 	}
+	cms, _, err := cmsFromBytes(bytes)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	return cms
+}
+
+// cmsFromBytes reads CMS written by bytes and returns the number of bytes read.
+func cmsFromBytes(bytes []byte) (*CountMinSketch, int, error) {
+	invalid := errors.New("countminsketch: invalid serialized sketch")
+	if len(bytes) < 8 {
+		return nil, 0, invalid
+	}
 	d := binary.LittleEndian.Uint32(bytes[:4])
 	w := binary.LittleEndian.Uint32(bytes[4:8])
-	cms, _ := NewCMS(d, w)
+	if uint64(len(bytes)-8) < uint64(d)*uint64(w)*8 {
+		return nil, 0, invalid
+	}
+	cms, err := NewCMS(d, w)
+	if err != nil {
+		return nil, 0, err
+	}
 	curr := 8
 	for i := uint32(0); i < d; i++ {
 		for j := uint32(0); j < w; j++ {
@@ -153,7 +229,25 @@ func DeserializeCMS(fileName string) *CountMinSketch {
 		}
 	}
 
-	return cms
+	return cms, curr, nil
+}
+
+// add adds the counters of other CMS, both must have the same d and w.
+func (s *CountMinSketch) add(other *CountMinSketch) {
+	for row := range s.table {
+		for column := range s.table[row] {
+			s.table[row][column] += other.table[row][column]
+		}
+	}
+}
+
+// clear sets all counters to zero.
+func (s *CountMinSketch) clear() {
+	for _, row := range s.table {
+		for i := range row {
+			row[i] = 0
+		}
+	}
 }
 
 // InfoCMS Prints out information about the structure.
@@ -0,0 +1,434 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"math"
+	"os"
+	"time"
+)
+
+const (
+	SketchPlain    = "plain"
+	SketchWindowed = "windowed"
+	SketchDecaying = "decaying"
+)
+
+// sketchClock returns the current time in nanoseconds for the time aware sketches. Tests replace it to move time.
+var sketchClock = func() int64 {
+	return time.Now().UnixNano()
+}
+
+// FrequencySketch is implemented by CountMinSketch and its time aware variants.
+type FrequencySketch interface {
+	Update(key string)
+	UpdateBy(key string, n uint64)
+	Estimate(key string) uint64
+	SetConservative(conservative bool)
+	SerializeCMS(fileName string)
+	bytes() []byte
+}
+
+// NewFrequencySketch returns an empty sketch of the type and update mode given in configuration, with the given error
+// estimates.
+func NewFrequencySketch(config *Config, epsilon, delta float64) (FrequencySketch, error) {
+	var sketch FrequencySketch
+	var err error
+	switch config.CMSType {
+	case SketchPlain, "":
+		sketch, err = NewCMSWithEstimates(epsilon, delta)
+	case SketchWindowed:
+		sketch, err = NewWindowedCMS(epsilon, delta, time.Duration(config.CMSWindow)*time.Second, config.CMSWindowSlots)
+	case SketchDecaying:
+		sketch, err = NewDecayingCMS(epsilon, delta, time.Duration(config.CMSHalfLife)*time.Second)
+	default:
+		err = errors.New("unknown cms type: " + config.CMSType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sketch.SetConservative(config.CMSConservative)
+	return sketch, nil
+}
+
+// FrequencySketchPath returns the file of the sketch type given in configuration, every type has its own file so
+// changing the type does not read a file of another type.
+func FrequencySketchPath(config *Config, directory string) string {
+	return frequencySketchFile(config, directory, "cms")
+}
+
+// frequencySketchFile returns the file of the sketch with the given name and the type given in configuration.
+func frequencySketchFile(config *Config, directory string, name string) string {
+	switch config.CMSType {
+	case SketchWindowed:
+		return directory + "/" + name + "-windowed.dat"
+	case SketchDecaying:
+		return directory + "/" + name + "-decaying.dat"
+	}
+	return directory + "/" + name + ".dat"
+}
+
+// LoadFrequencySketch Loads the sketch from the given file, if the file does not exist or is invalid an empty sketch is
+// returned.
+func LoadFrequencySketch(config *Config, fileName string, epsilon, delta float64) (FrequencySketch, error) {
+	sketch, err := DeserializeFrequencySketch(config, fileName)
+	if err == nil {
+		return sketch, nil
+	}
+	if !os.IsNotExist(err) {
+		fmt.Println(err)
+	}
+	return NewFrequencySketch(config, epsilon, delta)
+}
+
+// DeserializeFrequencySketch Deserializes the sketch of the type given in configuration from the given file.
+func DeserializeFrequencySketch(config *Config, fileName string) (FrequencySketch, error) {
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return frequencySketchFromBytes(config, bytes)
+}
+
+// frequencySketchFromBytes reads the sketch of the type given in configuration written by bytes.
+func frequencySketchFromBytes(config *Config, bytes []byte) (FrequencySketch, error) {
+	var sketch FrequencySketch
+	var err error
+	switch config.CMSType {
+	case SketchWindowed:
+		var wc *WindowedCMS
+		wc, err = windowedCMSFromBytes(bytes)
+		sketch = wc
+	case SketchDecaying:
+		var dc *DecayingCMS
+		dc, err = decayingCMSFromBytes(bytes)
+		sketch = dc
+	default:
+		var cms *CountMinSketch
+		cms, _, err = cmsFromBytes(bytes)
+		sketch = cms
+	}
+	if err != nil {
+		return nil, err
+	}
+	sketch.SetConservative(config.CMSConservative)
+	return sketch, nil
+}
+
+// writeSketchFile writes serialized sketch to the given file.
+func writeSketchFile(fileName string, bytes []byte) {
+	err := os.WriteFile(fileName, bytes, 0666)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
+// WindowedCMS counts only the keys seen in the last window. The window is split into slots, every slot is a
+// CountMinSketch, and the oldest slot is cleared and reused every window/slots.
+type WindowedCMS struct {
+	slots     []*CountMinSketch
+	interval  time.Duration
+	current   int
+	rotatedAt int64
+}
+
+// NewWindowedCMS returns a new WindowedCMS with the given window and number of slots.
+func NewWindowedCMS(epsilon, delta float64, window time.Duration, slots int) (*WindowedCMS, error) {
+	if slots <= 0 || window < time.Duration(slots) {
+		return nil, errors.New("countminsketch: window should be longer than 0 and split into at least one slot")
+	}
+	wc := &WindowedCMS{interval: window / time.Duration(slots), rotatedAt: sketchClock()}
+	for i := 0; i < slots; i++ {
+		cms, err := NewCMSWithEstimates(epsilon, delta)
+		if err != nil {
+			return nil, err
+		}
+		wc.slots = append(wc.slots, cms)
+	}
+	return wc, nil
+}
+
+// Window returns the time covered by the sketch.
+func (wc *WindowedCMS) Window() time.Duration {
+	return wc.interval * time.Duration(len(wc.slots))
+}
+
+// rotate clears one slot for every interval passed since the last rotation.
+func (wc *WindowedCMS) rotate() {
+	passed := (sketchClock() - wc.rotatedAt) / int64(wc.interval)
+	if passed <= 0 {
+		return
+	}
+	for i := int64(0); i < passed && i < int64(len(wc.slots)); i++ {
+		wc.current = (wc.current + 1) % len(wc.slots)
+		wc.slots[wc.current].clear()
+	}
+	wc.rotatedAt += passed * int64(wc.interval)
+}
+
+// Update updates the current slot for the given key.
+func (wc *WindowedCMS) Update(key string) {
+	wc.UpdateBy(key, 1)
+}
+
+// UpdateBy counts n occurrences of the given key in the current slot.
+func (wc *WindowedCMS) UpdateBy(key string, n uint64) {
+	wc.rotate()
+	wc.slots[wc.current].UpdateBy(key, n)
+}
+
+// SetConservative turns conservative update of every slot on or off.
+func (wc *WindowedCMS) SetConservative(conservative bool) {
+	for _, slot := range wc.slots {
+		slot.SetConservative(conservative)
+	}
+}
+
+// Estimate estimates the frequency of a key in the last window. Counters of all slots are summed before the minimum
+// is taken, which is never worse than summing estimates of every slot.
+func (wc *WindowedCMS) Estimate(key string) uint64 {
+	wc.rotate()
+	var min uint64
+	for row, column := range wc.slots[0].locations([]byte(key)) {
+		var sum uint64
+		for _, slot := range wc.slots {
+			sum += slot.table[row][column]
+		}
+		if row == 0 || sum < min {
+			min = sum
+		}
+	}
+	return min
+}
+
+// Merge adds the counters of another WindowedCMS with the same window, slots, d and w. Slots are matched by their age,
+// so sketches of separate nodes covering the same time can be combined.
+func (wc *WindowedCMS) Merge(other *WindowedCMS) error {
+	if len(wc.slots) != len(other.slots) || wc.interval != other.interval ||
+		wc.slots[0].d != other.slots[0].d || wc.slots[0].w != other.slots[0].w {
+		return errors.New("countminsketch: windowed sketches have different dimensions")
+	}
+	wc.rotate()
+	other.rotate()
+	n := len(wc.slots)
+	for age := 0; age < n; age++ {
+		wc.slots[(wc.current-age+n)%n].add(other.slots[(other.current-age+n)%n])
+	}
+	return nil
+}
+
+// SerializeCMS Serializes WindowedCMS structure to the given file.
+func (wc *WindowedCMS) SerializeCMS(fileName string) {
+	writeSketchFile(fileName, wc.bytes())
+}
+
+// bytes returns number of slots, current slot, interval, time of the last rotation and all slots.
+func (wc *WindowedCMS) bytes() []byte {
+	bytes := make([]byte, 24)
+	binary.LittleEndian.PutUint32(bytes[:4], uint32(len(wc.slots)))
+	binary.LittleEndian.PutUint32(bytes[4:8], uint32(wc.current))
+	binary.LittleEndian.PutUint64(bytes[8:16], uint64(wc.interval))
+	binary.LittleEndian.PutUint64(bytes[16:24], uint64(wc.rotatedAt))
+	for _, slot := range wc.slots {
+		bytes = append(bytes, slot.bytes()...)
+	}
+	return bytes
+}
+
+// windowedCMSFromBytes reads WindowedCMS written by SerializeCMS, slots that expired in the meantime are cleared on
+// the next use.
+func windowedCMSFromBytes(bytes []byte) (*WindowedCMS, error) {
+	invalid := errors.New("countminsketch: invalid serialized windowed sketch")
+	if len(bytes) < 24 {
+		return nil, invalid
+	}
+	slots := int(binary.LittleEndian.Uint32(bytes[:4]))
+	wc := &WindowedCMS{
+		current:   int(binary.LittleEndian.Uint32(bytes[4:8])),
+		interval:  time.Duration(binary.LittleEndian.Uint64(bytes[8:16])),
+		rotatedAt: int64(binary.LittleEndian.Uint64(bytes[16:24])),
+	}
+	if slots <= 0 || wc.current >= slots || wc.interval <= 0 {
+		return nil, invalid
+	}
+	curr := 24
+	for i := 0; i < slots; i++ {
+		cms, n, err := cmsFromBytes(bytes[curr:])
+		if err != nil {
+			return nil, err
+		}
+		wc.slots = append(wc.slots, cms)
+		curr += n
+	}
+	return wc, nil
+}
+
+// decayMaxExponent is the number of half-lives after which counters are rescaled, so they do not overflow.
+const decayMaxExponent = 60
+
+// DecayingCMS is a CountMinSketch whose counts halve every halfLife. An update at time t adds 2^((t-landmark)/halfLife)
+// and estimates are divided by 2^((now-landmark)/halfLife), so counters never have to be decayed one by one until the
+// landmark is moved forward.
+type DecayingCMS struct {
+	d            uint32
+	w            uint32
+	table        [][]float64
+	hashes       []hash.Hash32
+	halfLife     time.Duration
+	landmark     int64
+	conservative bool
+}
+
+// NewDecayingCMS returns a new DecayingCMS with the given half-life.
+func NewDecayingCMS(epsilon, delta float64, halfLife time.Duration) (*DecayingCMS, error) {
+	if halfLife <= 0 {
+		return nil, errors.New("countminsketch: half-life should be greater than 0")
+	}
+	cms, err := NewCMSWithEstimates(epsilon, delta)
+	if err != nil {
+		return nil, err
+	}
+	return newDecayingCMS(cms.d, cms.w, halfLife, sketchClock()), nil
+}
+
+func newDecayingCMS(d, w uint32, halfLife time.Duration, landmark int64) *DecayingCMS {
+	dc := &DecayingCMS{d: d, w: w, hashes: CreateCmsHashFunctions(d), halfLife: halfLife, landmark: landmark}
+	dc.table = make([][]float64, d)
+	for r := uint32(0); r < d; r++ {
+		dc.table[r] = make([]float64, w)
+	}
+	return dc
+}
+
+// exponent returns the number of half-lives passed since the landmark.
+func (dc *DecayingCMS) exponent(now int64) float64 {
+	return float64(now-dc.landmark) / float64(dc.halfLife)
+}
+
+// rescale moves the landmark to now when the weights get too large.
+func (dc *DecayingCMS) rescale(now int64) {
+	exponent := dc.exponent(now)
+	if exponent < decayMaxExponent {
+		return
+	}
+	factor := math.Exp2(-exponent)
+	for _, row := range dc.table {
+		for i := range row {
+			row[i] *= factor
+		}
+	}
+	dc.landmark = now
+}
+
+// Update updates a table for given key with the weight of the current time.
+func (dc *DecayingCMS) Update(key string) {
+	dc.UpdateBy(key, 1)
+}
+
+// UpdateBy counts n occurrences of the given key with the weight of the current time.
+func (dc *DecayingCMS) UpdateBy(key string, n uint64) {
+	now := sketchClock()
+	dc.rescale(now)
+	weight := float64(n) * math.Exp2(dc.exponent(now))
+	locations := cmsLocations(dc.hashes, dc.w, []byte(key))
+	if dc.conservative {
+		target := dc.estimate(locations) + weight
+		for row, column := range locations {
+			if dc.table[row][column] < target {
+				dc.table[row][column] = target
+			}
+		}
+		return
+	}
+	for row, column := range locations {
+		dc.table[row][column] += weight
+	}
+}
+
+// SetConservative turns conservative update on or off.
+func (dc *DecayingCMS) SetConservative(conservative bool) {
+	dc.conservative = conservative
+}
+
+// Estimate estimates the decayed frequency of a key.
+func (dc *DecayingCMS) Estimate(key string) uint64 {
+	now := sketchClock()
+	min := dc.estimate(cmsLocations(dc.hashes, dc.w, []byte(key)))
+	return uint64(math.Round(min * math.Exp2(-dc.exponent(now))))
+}
+
+// estimate returns the smallest counter at the given locations, not decayed.
+func (dc *DecayingCMS) estimate(locations []uint32) float64 {
+	var min float64
+	for row, column := range locations {
+		if row == 0 || dc.table[row][column] < min {
+			min = dc.table[row][column]
+		}
+	}
+	return min
+}
+
+// Merge adds the counts of another DecayingCMS with the same d, w and half-life, its counters are scaled to the
+// landmark of this sketch.
+func (dc *DecayingCMS) Merge(other *DecayingCMS) error {
+	if dc.d != other.d || dc.w != other.w || dc.halfLife != other.halfLife {
+		return errors.New("countminsketch: decaying sketches have different dimensions or half-life")
+	}
+	factor := math.Exp2(float64(other.landmark-dc.landmark) / float64(dc.halfLife))
+	for row := range dc.table {
+		for column := range dc.table[row] {
+			dc.table[row][column] += other.table[row][column] * factor
+		}
+	}
+	dc.rescale(sketchClock())
+	return nil
+}
+
+// SerializeCMS Serializes DecayingCMS structure to the given file.
+func (dc *DecayingCMS) SerializeCMS(fileName string) {
+	writeSketchFile(fileName, dc.bytes())
+}
+
+// bytes returns d, w, half-life, landmark and the table.
+func (dc *DecayingCMS) bytes() []byte {
+	bytes := make([]byte, 24, 24+8*int(dc.d)*int(dc.w))
+	binary.LittleEndian.PutUint32(bytes[:4], dc.d)
+	binary.LittleEndian.PutUint32(bytes[4:8], dc.w)
+	binary.LittleEndian.PutUint64(bytes[8:16], uint64(dc.halfLife))
+	binary.LittleEndian.PutUint64(bytes[16:24], uint64(dc.landmark))
+	el := make([]byte, 8)
+	for _, row := range dc.table {
+		for _, num := range row {
+			binary.LittleEndian.PutUint64(el, math.Float64bits(num))
+			bytes = append(bytes, el...)
+		}
+	}
+	return bytes
+}
+
+// decayingCMSFromBytes reads DecayingCMS written by SerializeCMS.
+func decayingCMSFromBytes(bytes []byte) (*DecayingCMS, error) {
+	invalid := errors.New("countminsketch: invalid serialized decaying sketch")
+	if len(bytes) < 24 {
+		return nil, invalid
+	}
+	d := binary.LittleEndian.Uint32(bytes[:4])
+	w := binary.LittleEndian.Uint32(bytes[4:8])
+	halfLife := time.Duration(binary.LittleEndian.Uint64(bytes[8:16]))
+	if d == 0 || w == 0 || halfLife <= 0 || uint64(len(bytes)-24) != uint64(d)*uint64(w)*8 {
+		return nil, invalid
+	}
+	dc := newDecayingCMS(d, w, halfLife, int64(binary.LittleEndian.Uint64(bytes[16:24])))
+	curr := 24
+	for _, row := range dc.table {
+		for i := range row {
+			row[i] = math.Float64frombits(binary.LittleEndian.Uint64(bytes[curr : curr+8]))
+			curr += 8
+		}
+	}
+	return dc, nil
+}
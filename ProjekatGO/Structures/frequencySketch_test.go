@@ -0,0 +1,135 @@
+package Structures
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeSketchClock replaces the clock of time aware sketches for the test, the returned function moves it forward.
+func fakeSketchClock(t *testing.T) func(time.Duration) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	sketchClock = func() int64 {
+		return now
+	}
+	t.Cleanup(func() {
+		sketchClock = func() int64 {
+			return time.Now().UnixNano()
+		}
+	})
+	return func(d time.Duration) {
+		now += int64(d)
+	}
+}
+
+// checkEstimate checks the estimate of the key, the sketches are large enough not to overestimate a few keys.
+func checkEstimate(t *testing.T, name string, sketch FrequencySketch, key string, expected uint64) {
+	t.Helper()
+	if estimate := sketch.Estimate(key); estimate != expected {
+		t.Errorf("%s: %s estimated %d times, expected %d", name, key, estimate, expected)
+	}
+}
+
+func TestWindowedCMSForgetsOldCounts(t *testing.T) {
+	advance := fakeSketchClock(t)
+	wc, err := NewWindowedCMS(0.01, 0.01, time.Minute, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wc.UpdateBy("a", 5)
+	advance(30 * time.Second)
+	wc.UpdateBy("a", 3)
+	wc.Update("b")
+	checkEstimate(t, "both slots", wc, "a", 8)
+	// The slot of the first update leaves the window a minute after it.
+	advance(35 * time.Second)
+	checkEstimate(t, "after rotation", wc, "a", 3)
+	checkEstimate(t, "after rotation", wc, "b", 1)
+	advance(time.Hour)
+	checkEstimate(t, "after the window", wc, "a", 0)
+	wc.Update("a")
+	checkEstimate(t, "new update", wc, "a", 1)
+}
+
+func TestWindowedCMSMergeAndSerialize(t *testing.T) {
+	advance := fakeSketchClock(t)
+	first, _ := NewWindowedCMS(0.01, 0.01, time.Minute, 6)
+	second, _ := NewWindowedCMS(0.01, 0.01, time.Minute, 6)
+	first.UpdateBy("a", 5)
+	second.UpdateBy("a", 1)
+	advance(40 * time.Second)
+	first.UpdateBy("b", 2)
+	second.UpdateBy("a", 3)
+	if err := first.Merge(second); err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate(t, "merged", first, "a", 9)
+	checkEstimate(t, "merged", first, "b", 2)
+
+	read, err := windowedCMSFromBytes(first.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate(t, "read", read, "a", 9)
+	// Slots of both sketches keep their age, so the merged first updates expire together.
+	advance(25 * time.Second)
+	checkEstimate(t, "read after rotation", read, "a", 3)
+	checkEstimate(t, "read after rotation", read, "b", 2)
+
+	other, _ := NewWindowedCMS(0.01, 0.01, time.Minute, 3)
+	if err := first.Merge(other); err == nil {
+		t.Error("sketches with different slots are merged")
+	}
+	if _, err := windowedCMSFromBytes(first.bytes()[:30]); err == nil {
+		t.Error("truncated windowed sketch is read")
+	}
+}
+
+func TestDecayingCMSHalvesCounts(t *testing.T) {
+	advance := fakeSketchClock(t)
+	dc, err := NewDecayingCMS(0.01, 0.01, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dc.UpdateBy("a", 8)
+	advance(10 * time.Second)
+	checkEstimate(t, "one half-life", dc, "a", 4)
+	dc.UpdateBy("a", 4)
+	advance(20 * time.Second)
+	checkEstimate(t, "three half-lives", dc, "a", 2)
+	// Counters are rescaled when weights get too large, estimates stay the same.
+	advance(decayMaxExponent * 10 * time.Second)
+	dc.UpdateBy("b", 16)
+	checkEstimate(t, "rescaled", dc, "b", 16)
+	checkEstimate(t, "rescaled", dc, "a", 0)
+	advance(10 * time.Second)
+	checkEstimate(t, "after rescale", dc, "b", 8)
+}
+
+func TestDecayingCMSMergeAndSerialize(t *testing.T) {
+	advance := fakeSketchClock(t)
+	first, _ := NewDecayingCMS(0.01, 0.01, 10*time.Second)
+	first.UpdateBy("a", 8)
+	advance(10 * time.Second)
+	second, _ := NewDecayingCMS(0.01, 0.01, 10*time.Second)
+	second.UpdateBy("a", 4)
+	if err := first.Merge(second); err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate(t, "merged", first, "a", 8)
+
+	read, err := decayingCMSFromBytes(first.bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkEstimate(t, "read", read, "a", 8)
+	advance(10 * time.Second)
+	checkEstimate(t, "read after a half-life", read, "a", 4)
+
+	other, _ := NewDecayingCMS(0.01, 0.01, time.Minute)
+	if err := first.Merge(other); err == nil {
+		t.Error("sketches with different half-lives are merged")
+	}
+	if _, err := decayingCMSFromBytes(first.bytes()[:30]); err == nil {
+		t.Error("truncated decaying sketch is read")
+	}
+}
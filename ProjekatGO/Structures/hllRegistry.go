@@ -0,0 +1,165 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// AllKeys prefix which matches every key.
+	AllKeys = ""
+)
+
+// HLLRegistry counts distinct keys, in total and for every prefix given in configuration.
+type HLLRegistry struct {
+	precision uint32
+	sketches  map[string]*HyperLogLog
+}
+
+// NewHLLRegistry returns a new HLLRegistry with an empty sketch for every prefix and for all keys.
+func NewHLLRegistry(precision uint32, prefixes []string) *HLLRegistry {
+	registry := &HLLRegistry{precision: precision, sketches: make(map[string]*HyperLogLog)}
+	registry.sketches[AllKeys] = NewHyperLogLog(precision)
+	for _, prefix := range prefixes {
+		registry.sketches[prefix] = NewHyperLogLog(precision)
+	}
+	return registry
+}
+
+// LoadHLLRegistry Loads sketches of the prefixes given in configuration from the given file, sketches of prefixes
+// that are not in the file start empty and sketches of prefixes removed from configuration are dropped.
+func LoadHLLRegistry(config *Config, fileName string) *HLLRegistry {
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println(err)
+		}
+		return NewHLLRegistry(config.HLLPrecision, config.HLLPrefixes)
+	}
+	registry, err := hllRegistryFromConfig(config, bytes)
+	if err != nil {
+		fmt.Println(err)
+		return NewHLLRegistry(config.HLLPrecision, config.HLLPrefixes)
+	}
+	return registry
+}
+
+// hllRegistryFromConfig returns a registry with the prefixes given in configuration and their sketches read from the
+// serialized registry.
+func hllRegistryFromConfig(config *Config, bytes []byte) (*HLLRegistry, error) {
+	saved, err := hllRegistryFromBytes(bytes)
+	if err != nil {
+		return nil, err
+	}
+	registry := NewHLLRegistry(config.HLLPrecision, config.HLLPrefixes)
+	for prefix, hll := range saved {
+		if _, ok := registry.sketches[prefix]; ok {
+			registry.sketches[prefix] = hll
+		}
+	}
+	return registry, nil
+}
+
+// hllRegistryFromBytes reads prefixes and their sketches written by Serialize.
+func hllRegistryFromBytes(bytes []byte) (map[string]*HyperLogLog, error) {
+	invalid := errors.New("hyperloglog: invalid registry file")
+	saved := make(map[string]*HyperLogLog)
+	curr := 0
+	for curr < len(bytes) {
+		var fields [2][]byte
+		for i := range fields {
+			if curr+8 > len(bytes) {
+				return nil, invalid
+			}
+			size := binary.LittleEndian.Uint64(bytes[curr : curr+8])
+			curr += 8
+			if size > uint64(len(bytes)-curr) {
+				return nil, invalid
+			}
+			fields[i] = bytes[curr : curr+int(size)]
+			curr += int(size)
+		}
+		hll := hllFromBytes(fields[1])
+		if hll == nil {
+			return nil, invalid
+		}
+		saved[string(fields[0])] = hll
+	}
+	return saved, nil
+}
+
+// Add adds the key to the sketch of all keys and to sketches of all prefixes it starts with.
+func (r *HLLRegistry) Add(key string) {
+	for prefix, hll := range r.sketches {
+		if strings.HasPrefix(key, prefix) {
+			hll.Add([]byte(key))
+		}
+	}
+}
+
+// Prefixes returns all registered prefixes, sorted.
+func (r *HLLRegistry) Prefixes() []string {
+	var prefixes []string
+	for prefix := range r.sketches {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// CountDistinct estimates the number of distinct keys with the given prefix, AllKeys counts all keys.
+func (r *HLLRegistry) CountDistinct(prefix string) (float64, error) {
+	hll, ok := r.sketches[prefix]
+	if !ok {
+		return 0, errors.New("prefix " + prefix + " is not registered")
+	}
+	return hll.Estimate(), nil
+}
+
+// CountDistinctUnion estimates the number of distinct keys which start with any of the given prefixes.
+func (r *HLLRegistry) CountDistinctUnion(prefixes ...string) (float64, error) {
+	union := NewHyperLogLog(r.precision)
+	for i, prefix := range prefixes {
+		hll, ok := r.sketches[prefix]
+		if !ok {
+			return 0, errors.New("prefix " + prefix + " is not registered")
+		}
+		if i == 0 {
+			union = hll.Copy()
+			continue
+		}
+		if err := union.Merge(hll); err != nil {
+			return 0, err
+		}
+	}
+	return union.Estimate(), nil
+}
+
+// Serialize serializes all sketches with their prefixes to the given file.
+func (r *HLLRegistry) Serialize(fileName string) {
+	writeSketchFile(fileName, r.bytes())
+}
+
+// bytes returns every prefix with its serialized sketch.
+func (r *HLLRegistry) bytes() []byte {
+	var bytes []byte
+	for _, prefix := range r.Prefixes() {
+		bytes = appendField(bytes, []byte(prefix))
+		bytes = appendField(bytes, r.sketches[prefix].bytes())
+	}
+	return bytes
+}
+
+// appendField appends size of the field and the field.
+func appendField(bytes []byte, field []byte) []byte {
+	size := make([]byte, 8)
+	binary.LittleEndian.PutUint64(size, uint64(len(field)))
+	bytes = append(bytes, size...)
+	return append(bytes, field...)
+}
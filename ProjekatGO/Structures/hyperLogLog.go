@@ -4,13 +4,170 @@ package Structures
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"github.com/spaolacci/murmur3"
 	"hash/fnv"
 	"math"
 	"math/bits"
 	"os"
+	"sort"
 )
 
+const (
+	HLLMinPrecision = 4
+	HLLMaxPrecision = 18
+	// DefaultHLLPrecision is used when precision is 0.
+	DefaultHLLPrecision = 14
+	// hllSparsePrecision precision of the sparse representation.
+	hllSparsePrecision = 25
+)
+
+// hllMagic marks the HyperLogLog++ file format, files without it are read as the legacy format.
+var hllMagic = []byte("HLL+")
+
+// hllThresholds cardinalities under which linear counting is more accurate than the bias corrected estimate, for
+// precisions 4 to 18 (HyperLogLog in Practice, Heule et al.).
+var hllThresholds = []float64{10, 20, 40, 80, 220, 400, 900, 1800, 3100, 6500, 11500, 20000, 50000, 120000, 350000}
+
+// HyperLogLog is a HyperLogLog++ sketch. Small sets are kept in a sparse map with precision 25, which is converted to
+// m dense registers when it would take more memory than them. Legacy sketches read from the old file format keep
+// using the old 32-bit hash, so counts of values added before and after loading them are not mixed up.
+type HyperLogLog struct {
+	m      uint64
+	p      uint32
+	reg    []uint8
+	sparse map[uint32]uint8
+	legacy bool
+}
+
+// NewHyperLogLog returns a new empty HyperLogLog in sparse mode, precision is limited to the range 4-18 and 0 means
+// the default precision.
+func NewHyperLogLog(precision uint32) *HyperLogLog {
+	p := precision
+	if p == 0 {
+		p = DefaultHLLPrecision
+	} else if p < HLLMinPrecision {
+		p = HLLMinPrecision
+	} else if p > HLLMaxPrecision {
+		p = HLLMaxPrecision
+	}
+	return &HyperLogLog{m: 1 << p, p: p, sparse: make(map[uint32]uint8)}
+}
+
+// P returns the precision.
+func (hll *HyperLogLog) P() uint32 {
+	return hll.p
+}
+
+// Add adds item into HyperLogLog.
+func (hll *HyperLogLog) Add(item []byte) {
+	if hll.legacy {
+		hll.addLegacy(item)
+		return
+	}
+	hash := murmur3.Sum64(item)
+	if hll.sparse != nil {
+		index := uint32(hash >> (64 - hllSparsePrecision))
+		rho := uint8(bits.LeadingZeros64(hash<<hllSparsePrecision|1<<(hllSparsePrecision-1)) + 1)
+		if rho > hll.sparse[index] {
+			hll.sparse[index] = rho
+		}
+		// Every sparse entry takes 4 bytes when serialized.
+		if uint64(len(hll.sparse))*4 > hll.m {
+			hll.toDense()
+		}
+		return
+	}
+	index := hash >> (64 - hll.p)
+	rho := uint8(bits.LeadingZeros64(hash<<hll.p|1<<(hll.p-1)) + 1)
+	if rho > hll.reg[index] {
+		hll.reg[index] = rho
+	}
+}
+
+// toDense converts the sparse representation to dense registers.
+func (hll *HyperLogLog) toDense() {
+	hll.reg = make([]uint8, hll.m)
+	for index, rho := range hll.sparse {
+		denseIndex, denseRho := hll.denseEntry(index, rho)
+		if denseRho > hll.reg[denseIndex] {
+			hll.reg[denseIndex] = denseRho
+		}
+	}
+	hll.sparse = nil
+}
+
+// denseEntry converts a sparse register to the dense register it belongs to. The bits of the sparse index that are
+// not part of the dense index come first in the dense rho.
+func (hll *HyperLogLog) denseEntry(index uint32, rho uint8) (uint32, uint8) {
+	q := hllSparsePrecision - hll.p
+	low := index & (1<<q - 1)
+	if low != 0 {
+		return index >> q, uint8(q) - uint8(bits.Len32(low)) + 1
+	}
+	return index >> q, uint8(q) + rho
+}
+
+// Merge adds all values of another HyperLogLog with the same precision, the result estimates the number of different
+// values in both. Legacy sketches can only be merged with legacy sketches.
+func (hll *HyperLogLog) Merge(other *HyperLogLog) error {
+	if hll.p != other.p || hll.legacy != other.legacy {
+		return errors.New("hyperloglog: sketches have different precision or format")
+	}
+	if hll.sparse != nil && other.sparse != nil {
+		for index, rho := range other.sparse {
+			if rho > hll.sparse[index] {
+				hll.sparse[index] = rho
+			}
+		}
+		if uint64(len(hll.sparse))*4 > hll.m {
+			hll.toDense()
+		}
+		return nil
+	}
+	if hll.sparse != nil {
+		hll.toDense()
+	}
+	if other.sparse != nil {
+		for index, rho := range other.sparse {
+			denseIndex, denseRho := hll.denseEntry(index, rho)
+			if denseRho > hll.reg[denseIndex] {
+				hll.reg[denseIndex] = denseRho
+			}
+		}
+		return nil
+	}
+	for i, val := range other.reg {
+		if val > hll.reg[i] {
+			hll.reg[i] = val
+		}
+	}
+	return nil
+}
+
+// Copy returns a copy of the HyperLogLog.
+func (hll *HyperLogLog) Copy() *HyperLogLog {
+	return hllFromBytes(hll.bytes())
+}
+
+// addLegacy adds item the way the old HyperLogLog did, with 32-bit FNV and trailing zeros.
+func (hll *HyperLogLog) addLegacy(item []byte) {
+	hash := fnv.New32()
+	_, err := hash.Write(item)
+	if err != nil {
+		return
+	}
+	num := hash.Sum32()
+
+	k := 32 - hll.p
+	value := bits.TrailingZeros32(num)
+	index := num >> k
+	if uint8(value) > hll.reg[index] {
+		hll.reg[index] = uint8(value)
+	}
+}
+
 func (hll *HyperLogLog) emptyCount() int {
 	sum := 0
 	for _, val := range hll.reg {
@@ -21,8 +178,54 @@ func (hll *HyperLogLog) emptyCount() int {
 	return sum
 }
 
+// hllAlpha returns the bias constant for m registers.
+func hllAlpha(m uint64) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	}
+	return 0.7213 / (1.0 + 1.079/float64(m))
+}
+
+// rawEstimate returns the HyperLogLog estimate of dense registers without corrections.
+func (hll *HyperLogLog) rawEstimate() float64 {
+	sum := 0.0
+	for _, val := range hll.reg {
+		sum += math.Exp2(-float64(val))
+	}
+	return hllAlpha(hll.m) * float64(hll.m) * float64(hll.m) / sum
+}
+
 // Estimate estimates the number of different values.
 func (hll *HyperLogLog) Estimate() float64 {
+	if hll.legacy {
+		return hll.estimateLegacy()
+	}
+	if hll.sparse != nil {
+		// Linear counting over the sparse registers.
+		m := float64(uint64(1) << hllSparsePrecision)
+		return math.Round(m * math.Log(m/(m-float64(len(hll.sparse)))))
+	}
+	m := float64(hll.m)
+	estimation := hll.rawEstimate()
+	if estimation <= 5*m {
+		estimation -= hllBias(hll.p).bias(estimation)
+	}
+	if emptyRegs := hll.emptyCount(); emptyRegs > 0 {
+		linear := m * math.Log(m/float64(emptyRegs))
+		if linear <= hllThresholds[hll.p-HLLMinPrecision] {
+			estimation = linear
+		}
+	}
+	return math.Round(estimation)
+}
+
+// estimateLegacy estimates the number of different values of a legacy sketch with the classic corrections.
+func (hll *HyperLogLog) estimateLegacy() float64 {
 	sum := 0.0
 	for _, val := range hll.reg {
 		sum += math.Pow(math.Pow(2.0, float64(val)), -1)
@@ -41,57 +244,54 @@ func (hll *HyperLogLog) Estimate() float64 {
 	return math.Ceil(estimation)
 }
 
-type HyperLogLog struct {
-	m   uint64
-	p   uint32
-	reg []uint8
-}
-
-// NewHyperLogLog returns a new HyperLogLog.
-func NewHyperLogLog(precision uint32) *HyperLogLog {
-	p := precision
-	m := math.Pow(2, float64(p))
-	reg := make([]uint8, int(m))
-	return &HyperLogLog{m: uint64(m), p: p, reg: reg}
-}
-
-// Add adds item into HyperLogLog.
-func (hll *HyperLogLog) Add(item []byte) {
-	hash := fnv.New32()
-	_, err := hash.Write(item)
-	if err != nil {
-		return
-	}
-	num := hash.Sum32()
-
-	k := 32 - hll.p
-	value := bits.TrailingZeros32(num)
-	index := num >> k
-	if uint8(value) > hll.reg[index] {
-		hll.reg[index] = uint8(value)
-	}
-}
-
 // Info prints out HyperLogLog data.
 func (hll *HyperLogLog) Info() {
 	fmt.Println("m:", hll.m)
 	fmt.Println("p:", hll.p)
-	fmt.Println("reg:", hll.reg)
+	if hll.sparse != nil {
+		fmt.Println("sparse registers:", len(hll.sparse))
+	} else {
+		fmt.Println("reg:", hll.reg)
+	}
+	fmt.Println("legacy:", hll.legacy)
+}
+
+// bytes returns the serialized HyperLogLog: legacy sketches in the old format, others as magic, precision, sparse
+// flag and either sparse entries (index << 6 | rho) or dense registers.
+func (hll *HyperLogLog) bytes() []byte {
+	if hll.legacy {
+		bytes := make([]byte, 12)
+		binary.LittleEndian.PutUint64(bytes[:8], hll.m)
+		binary.LittleEndian.PutUint32(bytes[8:12], hll.p)
+		return append(bytes, hll.reg...)
+	}
+	bytes := append([]byte{}, hllMagic...)
+	if hll.sparse == nil {
+		bytes = append(bytes, uint8(hll.p), 0)
+		return append(bytes, hll.reg...)
+	}
+	bytes = append(bytes, uint8(hll.p), 1)
+	indexes := make([]uint32, 0, len(hll.sparse))
+	for index := range hll.sparse {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+	entry := make([]byte, 4)
+	for _, index := range indexes {
+		binary.LittleEndian.PutUint32(entry, index<<6|uint32(hll.sparse[index]))
+		bytes = append(bytes, entry...)
+	}
+	return bytes
 }
 
 // Serialize serializes HyperLogLog to the given file.
 func (hll *HyperLogLog) Serialize(fileName string) {
-	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE, 0666)
+	file, err := os.OpenFile(fileName, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		fmt.Println(err)
 		return
 	}
-	bytes := make([]byte, 12)
-	binary.LittleEndian.PutUint64(bytes[:8], hll.m)
-	binary.LittleEndian.PutUint32(bytes[8:12], hll.p)
-	bytes = append(bytes, hll.reg...)
-
-	_, err = file.Write(bytes)
+	_, err = file.Write(hll.bytes())
 	if err != nil {
 		return
 	}
@@ -102,15 +302,87 @@ func (hll *HyperLogLog) Serialize(fileName string) {
 	}
 }
 
-// DeserializeHLL creates HyperLogLog from the given file.
+// DeserializeHLL creates HyperLogLog from the given file, it returns nil if the file is missing or invalid.
 func DeserializeHLL(fileName string) *HyperLogLog {
 	bytes, err := os.ReadFile(fileName)
 	if err != nil {
 		fmt.Println(err)
 		return nil
 	}
+	hll := hllFromBytes(bytes)
+	if hll == nil {
+		fmt.Println("hyperloglog: invalid file", fileName)
+	}
+	return hll
+}
+
+// hllFromBytes reads HyperLogLog written by bytes, it returns nil if the data is invalid.
+func hllFromBytes(bytes []byte) *HyperLogLog {
+	if len(bytes) >= len(hllMagic)+2 && string(bytes[:len(hllMagic)]) == string(hllMagic) {
+		p := uint32(bytes[len(hllMagic)])
+		sparse := bytes[len(hllMagic)+1] == 1
+		data := bytes[len(hllMagic)+2:]
+		if p < HLLMinPrecision || p > HLLMaxPrecision {
+			return nil
+		}
+		hll := NewHyperLogLog(p)
+		if !sparse {
+			if uint64(len(data)) != hll.m {
+				return nil
+			}
+			hll.sparse = nil
+			hll.reg = append([]uint8{}, data...)
+			return hll
+		}
+		if len(data)%4 != 0 {
+			return nil
+		}
+		for i := 0; i < len(data); i += 4 {
+			entry := binary.LittleEndian.Uint32(data[i : i+4])
+			hll.sparse[entry>>6] = uint8(entry & 63)
+		}
+		return hll
+	}
+	// Legacy format: m, p and registers.
+	if len(bytes) < 12 {
+		return nil
+	}
 	m := binary.LittleEndian.Uint64(bytes[:8])
 	p := binary.LittleEndian.Uint32(bytes[8:12])
-	reg := bytes[12 : 12+m]
-	return &HyperLogLog{m: m, p: p, reg: reg}
+	if p == 0 || p > 32 || m != uint64(1)<<p || uint64(len(bytes)-12) != m {
+		return nil
+	}
+	return &HyperLogLog{m: m, p: p, reg: append([]uint8{}, bytes[12:]...), legacy: true}
+}
+
+// hllBiasTable mean raw estimates and their bias for one precision.
+type hllBiasTable struct {
+	estimates []float64
+	biases    []float64
+}
+
+// hllBias returns the bias table of the given precision.
+func hllBias(p uint32) *hllBiasTable {
+	return &hllBiasTable{estimates: hllRawEstimates[p-HLLMinPrecision], biases: hllBiasData[p-HLLMinPrecision]}
+}
+
+// bias returns the mean bias of the 6 raw estimates in the table nearest to the given estimate. The published raw
+// estimates are not strictly increasing, so the nearest ones are found by their distance.
+func (t *hllBiasTable) bias(estimate float64) float64 {
+	const neighbours = 6
+	nearest := make([]int, len(t.estimates))
+	for i := range nearest {
+		nearest[i] = i
+	}
+	sort.Slice(nearest, func(i, j int) bool {
+		return math.Abs(t.estimates[nearest[i]]-estimate) < math.Abs(t.estimates[nearest[j]]-estimate)
+	})
+	if len(nearest) > neighbours {
+		nearest = nearest[:neighbours]
+	}
+	sum := 0.0
+	for _, i := range nearest {
+		sum += t.biases[i]
+	}
+	return sum / float64(len(nearest))
 }
@@ -0,0 +1,199 @@
+package Structures
+
+import (
+	"encoding/binary"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// filledHLL returns a HyperLogLog with the given precision and keys from first up to last, not included.
+func filledHLL(p uint32, first int, last int) *HyperLogLog {
+	hll := NewHyperLogLog(p)
+	for i := first; i < last; i++ {
+		hll.Add([]byte("key-" + strconv.Itoa(i)))
+	}
+	return hll
+}
+
+// checkHLLError checks that the estimate is within the given relative error of the real count.
+func checkHLLError(t *testing.T, name string, estimate float64, count int, maxError float64) {
+	t.Helper()
+	if relative := math.Abs(estimate-float64(count)) / float64(count); relative > maxError {
+		t.Errorf("%s: estimated %.0f of %d values, error %.4f is over %.4f", name, estimate, count, relative, maxError)
+	}
+}
+
+func TestHLLErrorBounds(t *testing.T) {
+	// Standard error of precision 14 is 1.04/sqrt(2^14) = 0.8%, dense estimates are allowed 3 times as much. Sparse
+	// estimates use precision 25 and are almost exact.
+	counts := []struct {
+		count    int
+		sparse   bool
+		maxError float64
+	}{
+		{100, true, 0.001},
+		{1000, true, 0.005},
+		{20000, false, 0.025},
+		{200000, false, 0.025},
+		{1000000, false, 0.025},
+	}
+	for _, c := range counts {
+		hll := filledHLL(14, 0, c.count)
+		if sparse := hll.sparse != nil; sparse != c.sparse {
+			t.Errorf("sketch of %d values is sparse: %v, expected %v", c.count, sparse, c.sparse)
+		}
+		checkHLLError(t, "precision 14", hll.Estimate(), c.count, c.maxError)
+	}
+}
+
+func TestHLLSparseToDense(t *testing.T) {
+	// Precision 10 has 1024 registers, the sparse map is converted once it has more than 256 entries.
+	hll := NewHyperLogLog(10)
+	dense := NewHyperLogLog(10)
+	dense.toDense()
+	for i := 0; hll.sparse != nil; i++ {
+		key := []byte("key-" + strconv.Itoa(i))
+		hll.Add(key)
+		dense.Add(key)
+		if i > 1000 {
+			t.Fatal("sparse sketch is not converted to dense registers")
+		}
+	}
+	if len(hll.reg) != 1024 {
+		t.Fatalf("%d dense registers, expected 1024", len(hll.reg))
+	}
+	// Sparse registers hold more bits of the hash, converted they must be the same as if values were added dense.
+	for i := range hll.reg {
+		if hll.reg[i] != dense.reg[i] {
+			t.Fatalf("register %d is %d after conversion, %d when added dense", i, hll.reg[i], dense.reg[i])
+		}
+	}
+	checkHLLError(t, "converted", hll.Estimate(), 257, 0.1)
+}
+
+func TestHLLBiasCorrection(t *testing.T) {
+	table := hllBias(14)
+	mean := func(biases []float64) float64 {
+		sum := 0.0
+		for _, bias := range biases {
+			sum += bias
+		}
+		return sum / float64(len(biases))
+	}
+	first := mean(table.biases[:6])
+	last := mean(table.biases[len(table.biases)-6:])
+	for _, c := range []struct {
+		estimate float64
+		expected float64
+	}{
+		{0, first},
+		{table.estimates[2], first},
+		{1e9, last},
+	} {
+		if bias := table.bias(c.estimate); math.Abs(bias-c.expected) > 1e-9 {
+			t.Errorf("bias of %.0f is %f, expected the mean of its 6 nearest estimates %f", c.estimate, bias,
+				c.expected)
+		}
+	}
+	// Between linear counting and 5m the raw estimate is corrected by the bias.
+	hll := filledHLL(14, 0, 20000)
+	raw := hll.rawEstimate()
+	if estimate := hll.Estimate(); math.Abs(estimate-20000) >= math.Abs(raw-20000) {
+		t.Errorf("corrected estimate %.0f is not better than the raw estimate %.0f", estimate, raw)
+	}
+	checkHLLError(t, "bias corrected", hll.Estimate(), 20000, 0.025)
+}
+
+func TestHLLMerge(t *testing.T) {
+	sparse := filledHLL(14, 0, 500)
+	if err := sparse.Merge(filledHLL(14, 250, 1000)); err != nil {
+		t.Fatal(err)
+	}
+	if sparse.sparse == nil {
+		t.Error("merge of small sparse sketches is converted to dense registers")
+	}
+	checkHLLError(t, "sparse with sparse", sparse.Estimate(), 1000, 0.005)
+
+	dense := filledHLL(14, 0, 30000)
+	if err := dense.Merge(filledHLL(14, 20000, 50000)); err != nil {
+		t.Fatal(err)
+	}
+	checkHLLError(t, "dense with dense", dense.Estimate(), 50000, 0.025)
+	if err := dense.Merge(filledHLL(14, 50000, 50100)); err != nil {
+		t.Fatal(err)
+	}
+	checkHLLError(t, "dense with sparse", dense.Estimate(), 50100, 0.025)
+
+	toDense := filledHLL(14, 0, 100)
+	if err := toDense.Merge(filledHLL(14, 0, 30000)); err != nil {
+		t.Fatal(err)
+	}
+	checkHLLError(t, "sparse with dense", toDense.Estimate(), 30000, 0.025)
+
+	if err := dense.Merge(NewHyperLogLog(12)); err == nil {
+		t.Error("sketches with different precision are merged")
+	}
+}
+
+func TestHLLSerialization(t *testing.T) {
+	dir := t.TempDir()
+	for name, hll := range map[string]*HyperLogLog{"sparse": filledHLL(12, 0, 300), "dense": filledHLL(12, 0, 30000)} {
+		fileName := filepath.Join(dir, name+".dat")
+		hll.Serialize(fileName)
+		read := DeserializeHLL(fileName)
+		if read == nil {
+			t.Fatal(name, "sketch is not read")
+		}
+		if (read.sparse != nil) != (hll.sparse != nil) || read.Estimate() != hll.Estimate() {
+			t.Errorf("%s: read sketch estimates %.0f, written %.0f", name, read.Estimate(), hll.Estimate())
+		}
+		if string(read.bytes()) != string(hll.bytes()) {
+			t.Errorf("%s: read sketch is serialized differently", name)
+		}
+		read.Add([]byte("new"))
+		hll.Add([]byte("new"))
+		if read.Estimate() != hll.Estimate() {
+			t.Errorf("%s: read sketch counts new values differently", name)
+		}
+	}
+	if hllFromBytes(append([]byte{}, hllMagic...)) != nil {
+		t.Error("truncated sketch is read")
+	}
+}
+
+func TestHLLReadsLegacyFormat(t *testing.T) {
+	// The old format is m, p and registers, values were added with 32-bit FNV.
+	old := &HyperLogLog{m: 1 << 10, p: 10, reg: make([]uint8, 1<<10), legacy: true}
+	for i := 0; i < 5000; i++ {
+		old.addLegacy([]byte("key-" + strconv.Itoa(i)))
+	}
+	data := make([]byte, 12)
+	binary.LittleEndian.PutUint64(data[:8], old.m)
+	binary.LittleEndian.PutUint32(data[8:12], old.p)
+	data = append(data, old.reg...)
+
+	hll := hllFromBytes(data)
+	if hll == nil || !hll.legacy {
+		t.Fatal("legacy sketch is not read")
+	}
+	if hll.Estimate() != old.estimateLegacy() {
+		t.Errorf("legacy sketch estimates %.0f, expected %.0f", hll.Estimate(), old.estimateLegacy())
+	}
+	if string(hll.bytes()) != string(data) {
+		t.Error("legacy sketch is not written in the old format")
+	}
+	// Values already counted don't change the registers, they are hashed the old way.
+	before := hll.Estimate()
+	hll.Add([]byte("key-1"))
+	if hll.Estimate() != before {
+		t.Error("value added before loading is counted again")
+	}
+	if err := hll.Merge(NewHyperLogLog(10)); err == nil {
+		t.Error("legacy sketch is merged with a new one")
+	}
+	if hllFromBytes(data[:len(data)-1]) != nil {
+		t.Error("truncated legacy sketch is read")
+	}
+}
@@ -0,0 +1,128 @@
+package Structures
+
+// Author: SV46/2020
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+type IOPriority int
+
+const (
+	IOPriorityFlush IOPriority = iota
+	IOPriorityCompaction
+)
+
+// String returns the name of the IOPriority.
+func (p IOPriority) String() string {
+	if p == IOPriorityFlush {
+		return "flush"
+	}
+	return "compaction"
+}
+
+type IORateLimiter struct {
+	mutex     sync.Mutex
+	rate      int64
+	available float64
+	last      time.Time
+	waiting   [2]int
+	written   [2]uint64
+	waited    [2]time.Duration
+}
+
+// NewIORateLimiter returns a new IORateLimiter which lets through rate bytes per second, 0 means unlimited.
+func NewIORateLimiter(rate int64) *IORateLimiter {
+	return &IORateLimiter{rate: rate, available: float64(rate), last: time.Now()}
+}
+
+// Rate returns the current rate in bytes per second.
+func (l *IORateLimiter) Rate() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate
+}
+
+// SetRate changes the rate at runtime, writers that are waiting pick up the new rate.
+func (l *IORateLimiter) SetRate(rate int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.refill()
+	l.rate = rate
+	if l.available > float64(rate) {
+		l.available = float64(rate)
+	}
+}
+
+// refill adds bytes for the time passed since the last refill, at most one second worth of bytes is kept.
+func (l *IORateLimiter) refill() {
+	now := time.Now()
+	l.available += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.available > float64(l.rate) {
+		l.available = float64(l.rate)
+	}
+	l.last = now
+}
+
+// Request blocks until n bytes can be written. Requests bigger than the available bytes are let through and paid back
+// by the following ones. Compaction requests wait while there are flush requests waiting.
+func (l *IORateLimiter) Request(n int, priority IOPriority) {
+	start := time.Now()
+	l.mutex.Lock()
+	l.waiting[priority]++
+	for {
+		if l.rate <= 0 {
+			break
+		}
+		l.refill()
+		if (priority == IOPriorityFlush || l.waiting[IOPriorityFlush] == 0) && l.available >= 0 {
+			l.available -= float64(n)
+			break
+		}
+		wait := time.Millisecond
+		if l.available < 0 {
+			wait = time.Duration(-l.available / float64(l.rate) * float64(time.Second))
+		}
+		l.mutex.Unlock()
+		time.Sleep(wait)
+		l.mutex.Lock()
+	}
+	l.waiting[priority]--
+	l.written[priority] += uint64(n)
+	l.waited[priority] += time.Since(start)
+	l.mutex.Unlock()
+}
+
+// Info prints out IORateLimiter data.
+func (l *IORateLimiter) Info() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	fmt.Println("Rate (bytes/s):", l.rate)
+	for _, priority := range []IOPriority{IOPriorityFlush, IOPriorityCompaction} {
+		fmt.Println(priority, "bytes:", l.written[priority], "waited:", l.waited[priority])
+	}
+}
+
+type limitedWriter struct {
+	writer   io.Writer
+	limiter  *IORateLimiter
+	priority IOPriority
+}
+
+// NewLimitedWriter returns a writer that requests every write from the limiter with the given priority before writing
+// it to the given writer. If limiter is nil, writes are not limited.
+func NewLimitedWriter(writer io.Writer, limiter *IORateLimiter, priority IOPriority) io.Writer {
+	if limiter == nil {
+		return writer
+	}
+	return &limitedWriter{writer: writer, limiter: limiter, priority: priority}
+}
+
+// Write writes p once the limiter lets it through.
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	lw.limiter.Request(len(p), lw.priority)
+	return lw.writer.Write(p)
+}
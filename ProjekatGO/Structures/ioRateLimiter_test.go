@@ -0,0 +1,39 @@
+package Structures
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIORateLimiterFlushNotStarvedByCompaction(t *testing.T) {
+	limiter := NewIORateLimiter(10000)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					limiter.Request(1000, IOPriorityCompaction)
+				}
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	// Sharing the rate equally with 4 compactions, the flush would take 2.5s.
+	start := time.Now()
+	for i := 0; i < 5; i++ {
+		limiter.Request(1000, IOPriorityFlush)
+	}
+	elapsed := time.Since(start)
+	close(stop)
+	wg.Wait()
+	if elapsed > 1500*time.Millisecond {
+		t.Errorf("flush of 5000 bytes at 10000 bytes/s took %v", elapsed)
+	}
+}
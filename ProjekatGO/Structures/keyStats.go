@@ -0,0 +1,89 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"fmt"
+)
+
+// KeyStats estimated number of operations on one key.
+type KeyStats struct {
+	Reads   uint64
+	Writes  uint64
+	Deletes uint64
+	Misses  uint64
+}
+
+// OperationStats keeps a separate frequency sketch for reads, writes, deletes and reads of missing keys.
+type OperationStats struct {
+	config  *Config
+	reads   FrequencySketch
+	writes  FrequencySketch
+	deletes FrequencySketch
+	misses  FrequencySketch
+}
+
+var operationSketches = []string{"reads", "writes", "deletes", "misses"}
+
+// sketches returns sketches in the order of operationSketches.
+func (ops *OperationStats) sketches() []*FrequencySketch {
+	return []*FrequencySketch{&ops.reads, &ops.writes, &ops.deletes, &ops.misses}
+}
+
+// LoadOperationStats Loads operation sketches of the type given in configuration from the given directory, missing
+// sketches start empty.
+func LoadOperationStats(config *Config, directory string, epsilon, delta float64) (*OperationStats, error) {
+	stats := &OperationStats{config: config}
+	for i, sketch := range stats.sketches() {
+		s, err := LoadFrequencySketch(config, frequencySketchFile(config, directory, "cms-"+operationSketches[i]),
+			epsilon, delta)
+		if err != nil {
+			return nil, err
+		}
+		*sketch = s
+	}
+	return stats, nil
+}
+
+// Read counts a read of the key, reads of keys that were not found are counted as misses too.
+func (ops *OperationStats) Read(key string, found bool) {
+	ops.reads.Update(key)
+	if !found {
+		ops.misses.Update(key)
+	}
+}
+
+// Write counts a write of the key.
+func (ops *OperationStats) Write(key string) {
+	ops.writes.Update(key)
+}
+
+// Delete counts a delete of the key.
+func (ops *OperationStats) Delete(key string) {
+	ops.deletes.Update(key)
+}
+
+// KeyStats returns estimated number of reads, writes, deletes and misses of the key.
+func (ops *OperationStats) KeyStats(key string) KeyStats {
+	return KeyStats{
+		Reads:   ops.reads.Estimate(key),
+		Writes:  ops.writes.Estimate(key),
+		Deletes: ops.deletes.Estimate(key),
+		Misses:  ops.misses.Estimate(key),
+	}
+}
+
+// Serialize Serializes all operation sketches to the given directory.
+func (ops *OperationStats) Serialize(directory string) {
+	for i, sketch := range ops.sketches() {
+		(*sketch).SerializeCMS(frequencySketchFile(ops.config, directory, "cms-"+operationSketches[i]))
+	}
+}
+
+// Info prints out estimated number of operations on the key.
+func (ks KeyStats) Info() {
+	fmt.Println("Reads:", ks.Reads)
+	fmt.Println("Writes:", ks.Writes)
+	fmt.Println("Deletes:", ks.Deletes)
+	fmt.Println("Misses:", ks.Misses)
+}
@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 )
 
 type Tree interface {
 	SetAttributes(s *SSTable, level int)
 	GetLatest(maxLevel int) *SSTable
+	GetAll(maxLevel int) []*SSTable
+	Scan(config *Config, blockCache *BlockCache, start string, end string) []Record
 	GenerateLevels(c *Config)
 }
 
@@ -20,12 +24,14 @@ type Lsm struct{}
 
 // SetAttributes sets SSTable attributes.
 func (lsm Lsm) SetAttributes(s *SSTable, level int) {
-	levelPath := "LSM/C" + strconv.Itoa(level)
+	setAttributes(s, "LSM/C"+strconv.Itoa(level))
+}
+
+// setAttributes sets attributes of a new SSTable in the given level directory.
+func setAttributes(s *SSTable, levelPath string) {
 	empty, _ := IsEmptyDir(levelPath)
-	var numSSTable int
-	if empty {
-		numSSTable = 1
-	} else {
+	numSSTable := 1
+	if !empty {
 		dirs, err := ioutil.ReadDir(levelPath)
 		if err != nil {
 			_, err2 := fmt.Fprintln(os.Stderr, err)
@@ -33,22 +39,15 @@ func (lsm Lsm) SetAttributes(s *SSTable, level int) {
 				return
 			}
 		}
-		var modTime time.Time
-		name := ""
+		// Temporary tables are counted too, so a new table never takes the name of one that is being written.
 		for _, dir := range dirs {
-			if dir.Mode().IsDir() {
-				if !dir.ModTime().Before(modTime) {
-					if dir.ModTime().After(modTime) {
-						name = ""
-					}
-					name = dir.Name()
+			if dir.Mode().IsDir() && strings.HasPrefix(dir.Name(), "SSTable") {
+				num, errNum := strconv.Atoi(strings.TrimSuffix(dir.Name()[7:], TempSuffix))
+				if errNum == nil && num >= numSSTable {
+					numSSTable = num + 1
 				}
 			}
 		}
-		if name != "" {
-			numSSTable, _ = strconv.Atoi(name[7:])
-			numSSTable++
-		}
 	}
 
 	directoryPath := levelPath + "/SSTable" + strconv.Itoa(numSSTable)
@@ -58,6 +57,7 @@ func (lsm Lsm) SetAttributes(s *SSTable, level int) {
 	s.SummaryPath = "sstable-summary.dat"
 	s.FilterPath = "sstable-filter.dat"
 	s.MerklePath = "metadata.dat"
+	s.StatsPath = "sstable-stats.dat"
 
 }
 
@@ -78,7 +78,7 @@ func (lsm Lsm) GetLatest(maxLevel int) *SSTable {
 			var modTime time.Time
 			name := ""
 			for _, dir := range dirs {
-				if dir.Mode().IsDir() {
+				if dir.Mode().IsDir() && isTableDir(dir.Name()) {
 					if !dir.ModTime().Before(modTime) {
 						if dir.ModTime().After(modTime) {
 							name = ""
@@ -93,6 +93,7 @@ func (lsm Lsm) GetLatest(maxLevel int) *SSTable {
 			s.SummaryPath = "sstable-summary.dat"
 			s.FilterPath = "sstable-filter.dat"
 			s.MerklePath = "metadata.dat"
+			s.StatsPath = "sstable-stats.dat"
 			break
 		} else {
 			maxLevel--
@@ -107,6 +108,39 @@ func (lsm Lsm) GetLatest(maxLevel int) *SSTable {
 
 }
 
+// GetAll returns all SSTables from the newest to the oldest, lower levels hold newer data.
+func (lsm Lsm) GetAll(maxLevel int) []*SSTable {
+	var tables []*SSTable
+	for level := 1; level < maxLevel; level++ {
+		levelTables := levelTables(level)
+		for i := len(levelTables) - 1; i >= 0; i-- {
+			tables = append(tables, levelTables[i])
+		}
+	}
+	return tables
+}
+
+// Scan returns the newest version of all records with keys in range [start, end] from all SSTables, sorted by key.
+// Deleted records are returned with Tombstone set, so they can hide older versions.
+func (lsm Lsm) Scan(config *Config, blockCache *BlockCache, start string, end string) []Record {
+	newest := make(map[string]Record)
+	for _, table := range lsm.GetAll(int(config.LSMLevels)) {
+		for _, record := range ScanTable(table, start, end, blockCache) {
+			if current, ok := newest[record.Key]; !ok || record.Timestamp > current.Timestamp {
+				newest[record.Key] = record
+			}
+		}
+	}
+	records := make([]Record, 0, len(newest))
+	for _, record := range newest {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Key < records[j].Key
+	})
+	return records
+}
+
 // GenerateLevels generates LSM levels based on configuration.
 func (lsm Lsm) GenerateLevels(c *Config) {
 	for i := 1; i < int(c.LSMLevels); i++ {
@@ -0,0 +1,62 @@
+package Structures
+
+import (
+	"os"
+	"testing"
+)
+
+// newTestStore creates an empty store in a temporary directory and makes it the working directory for the test.
+func newTestStore(t *testing.T) *Store {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	if err := os.MkdirAll("LSM", 0777); err != nil {
+		t.Fatal(err)
+	}
+	config := defaultConfig()
+	Lsm{}.GenerateLevels(config)
+	store := NewStore(config)
+	store.BlockCache = nil
+	return store
+}
+
+// flushTable forms a new SSTable on the first level from key and value pairs, an empty value is a tombstone.
+func flushTable(t *testing.T, store *Store, pairs ...string) *SSTable {
+	return formTable(t, store, "LSM/C1", pairs...)
+}
+
+// formTable forms a new SSTable in the given level directory from key and value pairs, an empty value is a tombstone.
+func formTable(t *testing.T, store *Store, levelPath string, pairs ...string) *SSTable {
+	memtable := NewMemtable(len(pairs), NewSkipList(4, []*SkipListNode{}))
+	for i := 0; i < len(pairs); i += 2 {
+		value := []byte("0" + pairs[i+1])
+		if pairs[i+1] == "" {
+			value = []byte("1")
+		}
+		memtable.Add(NewSkipListNode(pairs[i], value, nil))
+	}
+	nodes, head, tail := memtable.flush()
+	s := formSSTable(levelPath, nodes, head.Key(), tail.Key(), store.Config, store.IOLimiter)
+	if s.DirectoryPath == "" {
+		t.Fatal("SSTable was not formed")
+	}
+	return &s
+}
+
+// lookup returns the newest value of the key from SSTables, false if it doesn't exist or is deleted.
+func lookup(store *Store, key string) (string, bool) {
+	for _, table := range (Lsm{}).GetAll(int(store.Config.LSMLevels)) {
+		if record, f := FindRecord(table, key, nil); f {
+			return string(record.Value), !record.Tombstone
+		}
+	}
+	return "", false
+}
@@ -8,12 +8,25 @@ import (
 
 type Memtable struct {
 	maxSize  int
+	reserved int
 	skipList *SkipList
 }
 
 // NewMemtable returns a new Memtable.
 func NewMemtable(maxSize int, skipList *SkipList) *Memtable {
-	return &Memtable{maxSize: maxSize, skipList: skipList}
+	memtable := &Memtable{maxSize: maxSize, skipList: skipList}
+	if skipList.Size() > 0 {
+		for current := skipList.Head(); current != nil; {
+			if IsSystemKey(current.Key()) {
+				memtable.reserved++
+			}
+			if len(current.linkedNodes) == 0 {
+				break
+			}
+			current = current.linkedNodes[0]
+		}
+	}
+	return memtable
 }
 
 // MaxSize returns the max size of Memtable.
@@ -26,10 +39,14 @@ func (memtable *Memtable) SkipList() *SkipList {
 	return memtable.skipList
 }
 
-// Add adds a node to SkipList, and returns sorted SkipListNode, SkipList head and SkipList tail.
+// Add adds a node to SkipList, and returns sorted SkipListNode, SkipList head and SkipList tail. Keys reserved for the
+// store don't count towards the max size.
 func (memtable *Memtable) Add(node *SkipListNode) ([]*SkipListNode, *SkipListNode, *SkipListNode) {
+	if IsSystemKey(node.Key()) && memtable.skipList.FindDeleted(node.Key()) == nil {
+		memtable.reserved++
+	}
 	memtable.skipList.Add(node)
-	if memtable.skipList.Size() == memtable.maxSize {
+	if memtable.skipList.Size()-memtable.reserved == memtable.maxSize {
 		return memtable.flush()
 	}
 	return nil, nil, nil
@@ -51,6 +68,7 @@ func (memtable *Memtable) flush() ([]*SkipListNode, *SkipListNode, *SkipListNode
 	memtable.skipList.header = []*SkipListNode{}
 	memtable.skipList.height = 0
 	memtable.skipList.size = 0
+	memtable.reserved = 0
 	return ret, head, tail
 }
 
@@ -0,0 +1,37 @@
+package Structures
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestMemtableReservedKeysDontTakeSlots(t *testing.T) {
+	memtable := NewMemtable(3, NewSkipList(2, []*SkipListNode{}))
+	for i := 0; i < 2; i++ {
+		if nodes, _, _ := memtable.Add(NewSkipListNode("k"+strconv.Itoa(i), []byte("0v"), nil)); nodes != nil {
+			t.Fatalf("flushed after %d user keys", i+1)
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if nodes, _, _ := memtable.Add(NewSkipListNode(SketchesKey, []byte("0data"), nil)); nodes != nil {
+			t.Fatal("reserved key flushed the memtable")
+		}
+	}
+	nodes, _, _ := memtable.Add(NewSkipListNode("k2", []byte("0v"), nil))
+	if len(nodes) != 4 {
+		t.Fatalf("flushed %d nodes, want 3 user keys and the reserved key", len(nodes))
+	}
+}
+
+func TestNewMemtableCountsReservedKeys(t *testing.T) {
+	skipList := NewSkipList(2, []*SkipListNode{})
+	skipList.Add(NewSkipListNode("a", []byte("0v"), nil))
+	skipList.Add(NewSkipListNode(SketchesKey, []byte("0data"), nil))
+	memtable := NewMemtable(3, skipList)
+	if nodes, _, _ := memtable.Add(NewSkipListNode("b", []byte("0v"), nil)); nodes != nil {
+		t.Fatal("replayed reserved key took a slot")
+	}
+	if nodes, _, _ := memtable.Add(NewSkipListNode("c", []byte("0v"), nil)); nodes == nil {
+		t.Fatal("memtable did not flush after 3 user keys")
+	}
+}
@@ -0,0 +1,77 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"errors"
+	"golang.org/x/crypto/blake2b"
+)
+
+// HashAlgorithm hash function of Merkle trees, its ID is written in the header of the serialized tree.
+type HashAlgorithm uint8
+
+const (
+	HashSHA1    HashAlgorithm = 1
+	HashSHA256  HashAlgorithm = 2
+	HashBLAKE2b HashAlgorithm = 3
+
+	// DefaultMerkleHash hash of new trees, trees without a header were written with HashSHA1.
+	DefaultMerkleHash = HashSHA256
+)
+
+var hashNames = map[HashAlgorithm]string{HashSHA1: "sha1", HashSHA256: "sha256", HashBLAKE2b: "blake2b"}
+
+// ParseHashAlgorithm returns the algorithm with the given name, "" is the default one.
+func ParseHashAlgorithm(name string) (HashAlgorithm, error) {
+	if name == "" {
+		return DefaultMerkleHash, nil
+	}
+	for algorithm, n := range hashNames {
+		if n == name {
+			return algorithm, nil
+		}
+	}
+	return 0, errors.New("merkle: unknown hash " + name)
+}
+
+// String returns the name of the algorithm.
+func (a HashAlgorithm) String() string {
+	return hashNames[a]
+}
+
+// Size returns the size of the hash in bytes.
+func (a HashAlgorithm) Size() int {
+	switch a {
+	case HashSHA1:
+		return sha1.Size
+	case HashSHA256:
+		return sha256.Size
+	case HashBLAKE2b:
+		return blake2b.Size256
+	}
+	return 0
+}
+
+// Func returns the hash function, nil for an unknown algorithm.
+func (a HashAlgorithm) Func() func(data []byte) []byte {
+	switch a {
+	case HashSHA1:
+		return func(data []byte) []byte {
+			h := sha1.Sum(data)
+			return h[:]
+		}
+	case HashSHA256:
+		return func(data []byte) []byte {
+			h := sha256.Sum256(data)
+			return h[:]
+		}
+	case HashBLAKE2b:
+		return func(data []byte) []byte {
+			h := blake2b.Sum256(data)
+			return h[:]
+		}
+	}
+	return nil
+}
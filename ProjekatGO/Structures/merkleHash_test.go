@@ -0,0 +1,35 @@
+package Structures
+
+import (
+	"encoding/hex"
+	"testing"
+)
+
+// patternData returns n bytes counting up modulo 251, so blocks of longer inputs differ.
+func patternData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	return data
+}
+
+func TestBLAKE2b256KnownAnswers(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte{}, "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{[]byte("abc"), "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{patternData(127), "f2fe67ff342e21b8f45e8f2e0bcd1d9243245d50ee6c78042e9c491388791c72"},
+		{patternData(128), "c3582f71ebb2be66fa5dd750f80baae97554f3b015663c8be377cfcb2488c1d1"},
+		{patternData(129), "f7f3c46ba2564ff4c4c162da1f5b605f9f1c4aa6a20652a9f9a337c1a2f5b9c9"},
+		{patternData(256), "582f782226018ec33076bd8d1c42413530ac7e1126260ffc0f306ba3befc3f24"},
+		{patternData(1000), "b372d0608f720c8c3dd41e9c8eecb10143b41abe520b616607e754bf79c08331"},
+	}
+	for _, test := range tests {
+		if sum := hex.EncodeToString(HashBLAKE2b.Func()(test.data)); sum != test.expected {
+			t.Errorf("BLAKE2b-256 of %d bytes = %s, expected %s", len(test.data), sum, test.expected)
+		}
+	}
+}
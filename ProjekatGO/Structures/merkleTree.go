@@ -3,15 +3,28 @@ package Structures
 // Author: SV14/2020
 
 import (
+	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"os"
+	"io"
+	"io/ioutil"
 )
 
 //NOTE: check the serialization of the tree
 
+const (
+	// merkleMagic starts the header of serialized trees.
+	merkleMagic = "MRK2"
+	// legacyMerkleMagic starts the header of trees written before leafs and inner nodes were hashed apart.
+	legacyMerkleMagic = "MRKL"
+
+	// Prefixes of hashed leafs and inner nodes, so a leaf can't be passed off as an inner node.
+	leafPrefix  = 0x00
+	innerPrefix = 0x01
+)
+
 type Content interface {
 	CalculateHash() ([]byte, error)
 }
@@ -22,10 +35,34 @@ type MyContent struct {
 }
 
 func (mc MyContent) CalculateHash() ([]byte, error) {
+	return mc.hashWith(HashSHA1.Func()), nil
+}
+
+// hashWith hashes the content as a leaf with the given hash function. The key is prefixed with its length, so a
+// different split of the same bytes into key and value gives a different hash.
+func (mc MyContent) hashWith(hash func(data []byte) []byte) []byte {
+	data := make([]byte, 9, 9+len(mc.key)+len(mc.value))
+	data[0] = leafPrefix
+	binary.LittleEndian.PutUint64(data[1:], uint64(len(mc.key)))
+	data = append(data, mc.key...)
+	data = append(data, mc.value...)
+	return hash(data)
+}
+
+// legacyHashWith hashes key and value of the content like trees written with legacyMerkleMagic or without a header.
+func (mc MyContent) legacyHashWith(hash func(data []byte) []byte) []byte {
 	keyBytes := []byte(mc.key)
 	keyBytes = append(keyBytes, mc.value...)
-	hashVal := Hash(keyBytes)
-	return hashVal[:], nil
+	return hash(keyBytes)
+}
+
+// hashInner hashes an inner node from the hashes of its children.
+func hashInner(hash func(data []byte) []byte, left, right []byte) []byte {
+	data := make([]byte, 0, 1+len(left)+len(right))
+	data = append(data, innerPrefix)
+	data = append(data, left...)
+	data = append(data, right...)
+	return hash(data)
 }
 
 type Node struct {
@@ -39,13 +76,37 @@ type Node struct {
 type MerkleTree struct {
 	root         *Node
 	leafs        []*Node
-	hashFunction func(data []byte) [20]byte
+	keys         map[string]int
+	algorithm    HashAlgorithm
+	hashFunction func(data []byte) []byte
+	legacy       bool
 }
 
-// NewTree returns new MerkleTree based on given content
+// ProofStep hash of the sibling on the path from a leaf to the root, Left is true if the sibling is the left child.
+type ProofStep struct {
+	Hash []byte
+	Left bool
+}
+
+// NewTree returns new MerkleTree based on given content, hashed with DefaultMerkleHash.
 func NewTree(cs []Content) (*MerkleTree, error) {
+	return NewTreeWithHash(cs, DefaultMerkleHash)
+}
+
+// NewTreeWithHash returns new MerkleTree based on given content, hashed with the given algorithm.
+func NewTreeWithHash(cs []Content, algorithm HashAlgorithm) (*MerkleTree, error) {
+	return newTree(cs, algorithm, false)
+}
+
+// newTree returns new MerkleTree based on given content, legacy trees hash leafs and inner nodes without prefixes.
+func newTree(cs []Content, algorithm HashAlgorithm, legacy bool) (*MerkleTree, error) {
+	if algorithm.Func() == nil {
+		return nil, errors.New("merkle: unknown hash algorithm")
+	}
 	t := &MerkleTree{
-		hashFunction: Hash,
+		algorithm:    algorithm,
+		hashFunction: algorithm.Func(),
+		legacy:       legacy,
 	}
 	root, leafs, err := buildWithContent(cs, t)
 	if err != nil {
@@ -53,9 +114,24 @@ func NewTree(cs []Content) (*MerkleTree, error) {
 	}
 	t.root = root
 	t.leafs = leafs
+	var keys []string
+	for _, c := range cs {
+		if mc, ok := c.(MyContent); ok {
+			keys = append(keys, mc.key)
+		}
+	}
+	t.indexKeys(keys)
 	return t, nil
 }
 
+// indexKeys remembers the leaf of every key, keys are given in the order of the leafs.
+func (t *MerkleTree) indexKeys(keys []string) {
+	t.keys = make(map[string]int)
+	for i, key := range keys {
+		t.keys[key] = i
+	}
+}
+
 // buildWithContent builds leafs and calls other function to build intermediate nodes
 func buildWithContent(cs []Content, t *MerkleTree) (*Node, []*Node, error) {
 	if len(cs) == 0 {
@@ -63,9 +139,15 @@ func buildWithContent(cs []Content, t *MerkleTree) (*Node, []*Node, error) {
 	}
 	var leafs []*Node
 	for _, c := range cs {
-		hash, err := c.CalculateHash()
-		if err != nil {
-			return nil, nil, err
+		var hash []byte
+		if mc, ok := c.(MyContent); ok {
+			hash = t.leafHash(mc)
+		} else {
+			var err error
+			hash, err = c.CalculateHash()
+			if err != nil {
+				return nil, nil, err
+			}
 		}
 
 		leafs = append(leafs, &Node{
@@ -96,8 +178,7 @@ func buildIntermediate(nl []*Node, t *MerkleTree) (*Node, error) {
 		if i+1 == len(nl) {
 			right = i
 		}
-		chash := append(nl[left].data, nl[right].data...)
-		data20byte := t.hashFunction(chash)
+		hash := t.innerHash(nl[left].data, nl[right].data)
 		rightNode := nl[right]
 		if left == right {
 			rightNode = nil
@@ -105,7 +186,7 @@ func buildIntermediate(nl []*Node, t *MerkleTree) (*Node, error) {
 		n := &Node{
 			left:  nl[left],
 			right: rightNode,
-			data:  data20byte[:],
+			data:  hash,
 		}
 		nodes = append(nodes, n)
 		nl[left].parent = n
@@ -117,8 +198,24 @@ func buildIntermediate(nl []*Node, t *MerkleTree) (*Node, error) {
 	return buildIntermediate(nodes, t)
 }
 
+// leafHash hashes the content of a leaf.
+func (t *MerkleTree) leafHash(mc MyContent) []byte {
+	if t.legacy {
+		return mc.legacyHashWith(t.hashFunction)
+	}
+	return mc.hashWith(t.hashFunction)
+}
+
+// innerHash hashes an inner node from the hashes of its children.
+func (t *MerkleTree) innerHash(left, right []byte) []byte {
+	if t.legacy {
+		return t.hashFunction(append(append([]byte{}, left...), right...))
+	}
+	return hashInner(t.hashFunction, left, right)
+}
+
 // SerializeTree serializes a tree with the given root node and file where we want to serialize it
-func SerializeTree(root *Node, file *os.File, marker int) {
+func SerializeTree(root *Node, file io.Writer, marker int) {
 	if root == nil {
 		_ = binary.Write(file, binary.LittleEndian, marker)
 		return
@@ -128,6 +225,157 @@ func SerializeTree(root *Node, file *os.File, marker int) {
 	SerializeTree(root.right, file, marker)
 }
 
+// Serialize writes the header with the hash algorithm followed by the tree serialized by SerializeTree.
+func (t *MerkleTree) Serialize(file io.Writer) error {
+	magic := merkleMagic
+	if t.legacy {
+		magic = legacyMerkleMagic
+	}
+	_, err := file.Write(append([]byte(magic), byte(t.algorithm)))
+	if err != nil {
+		return err
+	}
+	SerializeTree(t.root, file, -1)
+	return nil
+}
+
+// DeserializeTree reads a tree written by Serialize. Trees written before the header was added have only the hashes
+// and use HashSHA1, they and trees with legacyMerkleMagic are read as legacy trees. Nil children are not written, so
+// the shape of the tree is rebuilt from the number of hashes: it only depends on the number of leafs, which is even. A
+// table without records has an empty file, nil is returned for it.
+func DeserializeTree(file io.Reader) (*MerkleTree, error) {
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	algorithm := HashSHA1
+	legacy := true
+	// Trees without a header are decided by their size alone: the header is 5 bytes and hashes are 20 or 32 bytes, so
+	// only trees without it have a size divisible by the SHA1 size.
+	if len(data)%HashSHA1.Size() != 0 {
+		if len(data) <= len(merkleMagic) {
+			return nil, errors.New("merkle: invalid tree header")
+		}
+		magic := string(data[:len(merkleMagic)])
+		if magic != merkleMagic && magic != legacyMerkleMagic {
+			return nil, errors.New("merkle: invalid tree header")
+		}
+		algorithm = HashAlgorithm(data[len(merkleMagic)])
+		legacy = magic == legacyMerkleMagic
+		data = data[len(merkleMagic)+1:]
+	}
+	size := algorithm.Size()
+	if size == 0 {
+		return nil, errors.New("merkle: unknown hash algorithm")
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	if len(data)%size != 0 {
+		return nil, errors.New("merkle: invalid tree size")
+	}
+	count := len(data) / size
+	leafCount := 2
+	for treeSize(leafCount) < count {
+		leafCount += 2
+	}
+	if treeSize(leafCount) != count {
+		return nil, errors.New("merkle: invalid number of nodes")
+	}
+	t := &MerkleTree{algorithm: algorithm, hashFunction: algorithm.Func(), legacy: legacy}
+	for i := 0; i < leafCount; i++ {
+		t.leafs = append(t.leafs, &Node{leaf: true})
+	}
+	t.root, err = buildIntermediate(t.leafs, t)
+	if err != nil {
+		return nil, err
+	}
+	fillTree(t.root, data, size)
+	return t, nil
+}
+
+// treeSize returns the number of nodes in a tree with the given number of leafs.
+func treeSize(leafCount int) int {
+	size := leafCount
+	for n := leafCount; n > 1; {
+		n = (n + 1) / 2
+		size += n
+	}
+	return size
+}
+
+// fillTree sets hashes of the given size to the nodes in the order SerializeTree wrote them and returns the unused
+// data.
+func fillTree(root *Node, data []byte, size int) []byte {
+	if root == nil {
+		return data
+	}
+	root.data = data[:size]
+	data = fillTree(root.left, data[size:], size)
+	return fillTree(root.right, data, size)
+}
+
+// Proof returns hashes of the siblings on the path from the leaf of the key to the root.
+func (t *MerkleTree) Proof(key string) ([]ProofStep, error) {
+	i, ok := t.keys[key]
+	if !ok || i >= len(t.leafs) {
+		return nil, errors.New("merkle: key " + key + " is not in the tree")
+	}
+	var proof []ProofStep
+	for node := t.leafs[i]; node.parent != nil; node = node.parent {
+		parent := node.parent
+		if parent.left == node {
+			// A node without a sibling is hashed with itself.
+			sibling := node
+			if parent.right != nil {
+				sibling = parent.right
+			}
+			proof = append(proof, ProofStep{Hash: sibling.data})
+		} else {
+			proof = append(proof, ProofStep{Hash: parent.left.data, Left: true})
+		}
+	}
+	return proof, nil
+}
+
+// VerifyProof checks that the key with the value belongs to the tree with the given root, hashed with the given
+// algorithm. The algorithm of a table is in the header of its tree, see MerkleTree.Algorithm.
+func VerifyProof(algorithm HashAlgorithm, root []byte, key string, value []byte, proof []ProofStep) bool {
+	return verifyProof(algorithm, false, root, key, value, proof)
+}
+
+// verifyProof checks the proof of a tree hashed with the given algorithm, legacy or not.
+func verifyProof(algorithm HashAlgorithm, legacy bool, root []byte, key string, value []byte, proof []ProofStep) bool {
+	if algorithm.Func() == nil {
+		return false
+	}
+	t := &MerkleTree{algorithm: algorithm, hashFunction: algorithm.Func(), legacy: legacy}
+	hash := t.leafHash(MyContent{key: key, value: value})
+	for _, step := range proof {
+		if step.Left {
+			hash = t.innerHash(step.Hash, hash)
+		} else {
+			hash = t.innerHash(hash, step.Hash)
+		}
+	}
+	return bytes.Equal(hash, root)
+}
+
+// Legacy returns true if the tree was written before leafs and inner nodes were hashed apart.
+func (t *MerkleTree) Legacy() bool {
+	return t.legacy
+}
+
+// Algorithm returns the hash algorithm of the tree.
+func (t *MerkleTree) Algorithm() HashAlgorithm {
+	return t.algorithm
+}
+
+// Root returns the hash of the root.
+func (t *MerkleTree) Root() []byte {
+	return t.root.data
+}
+
 // PrintTree used for testing if the tree was well-made
 func (t *MerkleTree) PrintTree() {
 	stringTraversal(t.root)
@@ -0,0 +1,131 @@
+package Structures
+
+import (
+	"bytes"
+	"testing"
+)
+
+func testContents(pairs ...string) []Content {
+	var contents []Content
+	for i := 0; i < len(pairs); i += 2 {
+		contents = append(contents, MyContent{key: pairs[i], value: []byte(pairs[i+1])})
+	}
+	return contents
+}
+
+func TestProofDoesNotVerifyOtherKeyValueSplit(t *testing.T) {
+	tree, err := NewTree(testContents("ab", "c", "d", "e", "f", "g"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	proof, err := tree.Proof("ab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !VerifyProof(tree.Algorithm(), tree.Root(), "ab", []byte("c"), proof) {
+		t.Fatal("proof of (ab, c) does not verify")
+	}
+	if VerifyProof(tree.Algorithm(), tree.Root(), "a", []byte("bc"), proof) {
+		t.Fatal("proof of (ab, c) verifies (a, bc)")
+	}
+}
+
+func TestLeafIsNotInnerNode(t *testing.T) {
+	hash := DefaultMerkleHash.Func()
+	left := MyContent{key: "a", value: []byte("1")}.hashWith(hash)
+	right := MyContent{key: "b", value: []byte("2")}.hashWith(hash)
+	if bytes.Equal(hashInner(hash, left, right), hash(append(append([]byte{}, left...), right...))) {
+		t.Fatal("inner node is hashed like its concatenated children")
+	}
+}
+
+func TestDeserializeTreeKeepsScheme(t *testing.T) {
+	contents := testContents("a", "1", "b", "2", "c", "3")
+	for _, legacy := range []bool{false, true} {
+		tree, err := newTree(contents, HashSHA1, legacy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if legacy {
+			// Trees written before the header was added.
+			SerializeTree(tree.root, &buf, -1)
+		} else if err := tree.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		read, err := DeserializeTree(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read.Legacy() != legacy || !bytes.Equal(read.Root(), tree.Root()) {
+			t.Fatalf("legacy %v: read legacy %v, roots equal %v", legacy, read.Legacy(),
+				bytes.Equal(read.Root(), tree.Root()))
+		}
+		proof, err := tree.Proof("b")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !verifyProof(read.Algorithm(), read.Legacy(), read.Root(), "b", []byte("2"), proof) {
+			t.Fatalf("legacy %v: proof does not verify against the read tree", legacy)
+		}
+	}
+}
+
+func TestDeserializeTreeDecidesHeaderBySize(t *testing.T) {
+	contents := testContents("a", "1", "b", "2", "c", "3")
+	legacyTree, err := newTree(contents, HashSHA1, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	SerializeTree(legacyTree.root, &buf, -1)
+	// A tree without a header which starts with the magic by chance is still read without a header.
+	data := buf.Bytes()
+	copy(data, merkleMagic+string([]byte{byte(HashSHA256)}))
+	read, err := DeserializeTree(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !read.Legacy() || read.Algorithm() != HashSHA1 || !bytes.HasPrefix(read.Root(), []byte(merkleMagic)) {
+		t.Errorf("headerless tree starting with the magic is read as legacy %v with %s", read.Legacy(),
+			read.Algorithm())
+	}
+
+	for _, algorithm := range []HashAlgorithm{HashSHA1, HashSHA256, HashBLAKE2b} {
+		tree, err := NewTreeWithHash(contents, algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if err := tree.Serialize(&buf); err != nil {
+			t.Fatal(err)
+		}
+		read, err := DeserializeTree(&buf)
+		if err != nil {
+			t.Fatal(algorithm, err)
+		}
+		if read.Legacy() || read.Algorithm() != algorithm || !bytes.Equal(read.Root(), tree.Root()) {
+			t.Errorf("%s tree is read as legacy %v with %s", algorithm, read.Legacy(), read.Algorithm())
+		}
+	}
+
+	if _, err := DeserializeTree(bytes.NewReader(append([]byte("XXXX\x02"), data[:32]...))); err == nil {
+		t.Error("tree with an invalid header is read")
+	}
+}
+
+func TestVerifyProofWithTreeAlgorithm(t *testing.T) {
+	for _, algorithm := range []HashAlgorithm{HashSHA1, HashSHA256, HashBLAKE2b} {
+		tree, err := NewTreeWithHash(testContents("a", "1", "b", "2", "c", "3"), algorithm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		proof, err := tree.Proof("c")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !VerifyProof(tree.Algorithm(), tree.Root(), "c", []byte("3"), proof) {
+			t.Errorf("%s proof does not verify", algorithm)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// NegativeCache remembers keys which are known to be absent, either never written or deleted, so repeated misses do
+// not have to read SSTables. It holds at most size keys, the least recently used key is evicted first.
+type NegativeCache struct {
+	mutex      sync.Mutex
+	size       int
+	listOfKeys *list.List
+	keys       map[string]*list.Element
+	lookups    uint64
+	hits       uint64
+}
+
+// NewNegativeCache returns a new NegativeCache which holds at most size keys.
+func NewNegativeCache(size int) (*NegativeCache, error) {
+	if size <= 0 {
+		return nil, errors.New("size of negative cache must be grater than 0")
+	}
+	return &NegativeCache{
+		size:       size,
+		listOfKeys: list.New(),
+		keys:       make(map[string]*list.Element),
+	}, nil
+}
+
+// Check returns true if the key is known to be absent. Every call is counted as a lookup.
+func (nc *NegativeCache) Check(key string) bool {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	nc.lookups++
+	if entry, ok := nc.keys[key]; ok {
+		nc.listOfKeys.MoveToFront(entry)
+		nc.hits++
+		return true
+	}
+	return false
+}
+
+// RecordHit counts a lookup which found a tombstone before reaching the negative cache.
+func (nc *NegativeCache) RecordHit() {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	nc.lookups++
+	nc.hits++
+}
+
+// Add marks the key as absent.
+func (nc *NegativeCache) Add(key string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	if entry, ok := nc.keys[key]; ok {
+		nc.listOfKeys.MoveToFront(entry)
+		return
+	}
+	nc.keys[key] = nc.listOfKeys.PushFront(key)
+	if nc.listOfKeys.Len() > nc.size {
+		oldest := nc.listOfKeys.Back()
+		nc.listOfKeys.Remove(oldest)
+		delete(nc.keys, oldest.Value.(string))
+	}
+}
+
+// Remove removes the key, it has to be called on every put of the key.
+func (nc *NegativeCache) Remove(key string) {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	if entry, ok := nc.keys[key]; ok {
+		nc.listOfKeys.Remove(entry)
+		delete(nc.keys, key)
+	}
+}
+
+// Info prints out NegativeCache data.
+func (nc *NegativeCache) Info() {
+	nc.mutex.Lock()
+	defer nc.mutex.Unlock()
+	fmt.Println("Size:", nc.size)
+	fmt.Println("Keys:", len(nc.keys))
+	fmt.Println("Lookups:", nc.lookups)
+	fmt.Println("Negative hits:", nc.hits)
+	if nc.lookups > 0 {
+		fmt.Printf("Negative hit ratio: %.2f\n", float64(nc.hits)/float64(nc.lookups))
+	}
+}
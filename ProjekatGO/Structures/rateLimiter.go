@@ -0,0 +1,260 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"time"
+)
+
+const (
+	LimiterBucket = "bucket"
+	LimiterWindow = "window"
+	LimiterGCRA   = "gcra"
+)
+
+// RetryNever is returned by RetryAfter and Allow for requests of more tokens than the capacity, they are never allowed.
+const RetryNever = time.Duration(math.MaxInt64)
+
+// limiterClock returns the current time in nanoseconds for all rate limiters. Tests replace it to move time.
+var limiterClock = func() int64 {
+	return time.Now().UnixNano()
+}
+
+// RateLimiter is implemented by all rate limiting algorithms. At most Capacity tokens can be used in one time window.
+type RateLimiter interface {
+	// Allow removes n tokens if they are available, otherwise it returns how long to wait.
+	Allow(n int) (bool, time.Duration)
+	// RetryAfter returns how long to wait until n tokens are available, 0 if they are available now and RetryNever if
+	// n is more than the capacity.
+	RetryAfter(n int) time.Duration
+	// Charge removes n tokens even if they are not available.
+	Charge(n int)
+	Tokens() int
+	Capacity() int
+	// State returns the state of the limiter, so it can be restored after a restart.
+	State() []byte
+	// SetState restores the state returned by State. Time that passed in between is counted as usual.
+	SetState(state []byte) error
+}
+
+// NewRateLimiter returns a rate limiter of the given kind which allows capacity tokens every timeRate seconds.
+func NewRateLimiter(kind string, capacity int, timeRate int) (RateLimiter, error) {
+	switch kind {
+	case LimiterBucket, "":
+		return NewBucketWithRate(capacity, timeRate), nil
+	case LimiterWindow:
+		return NewSlidingWindowLog(capacity, timeRate), nil
+	case LimiterGCRA:
+		return NewGCRA(capacity, timeRate), nil
+	}
+	return nil, errors.New("unknown rate limiter: " + kind)
+}
+
+// limiterKind returns the type of the rate limiter, as it is named in the configuration file.
+func limiterKind(limiter RateLimiter) string {
+	switch limiter.(type) {
+	case *SlidingWindowLog:
+		return LimiterWindow
+	case *GCRA:
+		return LimiterGCRA
+	}
+	return LimiterBucket
+}
+
+var errLimiterState = errors.New("invalid rate limiter state")
+
+type windowEntry struct {
+	timeStamp int64
+	tokens    int
+}
+
+// SlidingWindowLog remembers every request of the last window and allows a request only if all of them together
+// with the new one use at most capacity tokens.
+type SlidingWindowLog struct {
+	capacity int
+	window   int64
+	log      []windowEntry
+	used     int
+}
+
+// NewSlidingWindowLog returns a new SlidingWindowLog with a window of timeRate seconds.
+func NewSlidingWindowLog(capacity int, timeRate int) *SlidingWindowLog {
+	return &SlidingWindowLog{capacity: capacity, window: int64(timeRate) * int64(time.Second)}
+}
+
+// expire removes requests older than the window.
+func (w *SlidingWindowLog) expire(now int64) {
+	i := 0
+	for i < len(w.log) && now-w.log[i].timeStamp >= w.window {
+		w.used -= w.log[i].tokens
+		i++
+	}
+	w.log = w.log[i:]
+}
+
+// RetryAfter returns how long to wait until enough old requests leave the window.
+func (w *SlidingWindowLog) RetryAfter(n int) time.Duration {
+	if n > w.capacity {
+		return RetryNever
+	}
+	now := limiterClock()
+	w.expire(now)
+	excess := w.used + n - w.capacity
+	for _, entry := range w.log {
+		if excess <= 0 {
+			break
+		}
+		excess -= entry.tokens
+		if excess <= 0 {
+			return time.Duration(entry.timeStamp + w.window - now)
+		}
+	}
+	return 0
+}
+
+// Allow logs the request if there is room for it in the window.
+func (w *SlidingWindowLog) Allow(n int) (bool, time.Duration) {
+	retryAfter := w.RetryAfter(n)
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	w.Charge(n)
+	return true, 0
+}
+
+// Charge logs the request.
+func (w *SlidingWindowLog) Charge(n int) {
+	now := limiterClock()
+	w.expire(now)
+	w.log = append(w.log, windowEntry{timeStamp: now, tokens: n})
+	w.used += n
+}
+
+// Tokens returns the number of tokens left in the current window.
+func (w *SlidingWindowLog) Tokens() int {
+	w.expire(limiterClock())
+	return w.capacity - w.used
+}
+
+// Capacity returns the maximum number of tokens in one window.
+func (w *SlidingWindowLog) Capacity() int {
+	return w.capacity
+}
+
+// State returns all requests of the window.
+func (w *SlidingWindowLog) State() []byte {
+	state := make([]byte, 0, 16*len(w.log))
+	entry := make([]byte, 16)
+	for _, e := range w.log {
+		binary.LittleEndian.PutUint64(entry[:8], uint64(e.timeStamp))
+		binary.LittleEndian.PutUint64(entry[8:], uint64(e.tokens))
+		state = append(state, entry...)
+	}
+	return state
+}
+
+// SetState restores requests of the window, the ones that are too old are removed on the next use.
+func (w *SlidingWindowLog) SetState(state []byte) error {
+	if len(state)%16 != 0 {
+		return errLimiterState
+	}
+	w.log = nil
+	w.used = 0
+	for i := 0; i < len(state); i += 16 {
+		e := windowEntry{
+			timeStamp: int64(binary.LittleEndian.Uint64(state[i : i+8])),
+			tokens:    int(binary.LittleEndian.Uint64(state[i+8 : i+16])),
+		}
+		w.log = append(w.log, e)
+		w.used += e.tokens
+	}
+	return nil
+}
+
+// GCRA is the Generic Cell Rate Algorithm. It keeps only the theoretical arrival time (tat) of the next request, one
+// token moves it by interval, and a request is allowed if tat would not go more than burst past the current time.
+type GCRA struct {
+	capacity int
+	interval int64
+	burst    int64
+	tat      int64
+}
+
+// NewGCRA returns a new GCRA which allows capacity tokens every timeRate seconds.
+func NewGCRA(capacity int, timeRate int) *GCRA {
+	g := &GCRA{capacity: capacity, burst: int64(timeRate) * int64(time.Second)}
+	if capacity > 0 {
+		g.interval = g.burst / int64(capacity)
+	}
+	return g
+}
+
+// next returns tat after n tokens are used.
+func (g *GCRA) next(n int, now int64) int64 {
+	tat := g.tat
+	if tat < now {
+		tat = now
+	}
+	return tat + int64(n)*g.interval
+}
+
+// RetryAfter returns how long to wait until n tokens can be used.
+func (g *GCRA) RetryAfter(n int) time.Duration {
+	if n > g.capacity {
+		return RetryNever
+	}
+	now := limiterClock()
+	allowAt := g.next(n, now) - g.burst
+	if allowAt > now {
+		return time.Duration(allowAt - now)
+	}
+	return 0
+}
+
+// Allow moves tat if the request is allowed.
+func (g *GCRA) Allow(n int) (bool, time.Duration) {
+	retryAfter := g.RetryAfter(n)
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	g.Charge(n)
+	return true, 0
+}
+
+// Charge moves tat even if the request is not allowed.
+func (g *GCRA) Charge(n int) {
+	g.tat = g.next(n, limiterClock())
+}
+
+// Tokens returns the number of tokens which can be used now.
+func (g *GCRA) Tokens() int {
+	if g.interval == 0 {
+		return g.capacity
+	}
+	now := limiterClock()
+	return int((g.burst - (g.next(0, now) - now)) / g.interval)
+}
+
+// Capacity returns the maximum burst in tokens.
+func (g *GCRA) Capacity() int {
+	return g.capacity
+}
+
+// State returns the theoretical arrival time.
+func (g *GCRA) State() []byte {
+	state := make([]byte, 8)
+	binary.LittleEndian.PutUint64(state, uint64(g.tat))
+	return state
+}
+
+// SetState restores the theoretical arrival time.
+func (g *GCRA) SetState(state []byte) error {
+	if len(state) != 8 {
+		return errLimiterState
+	}
+	g.tat = int64(binary.LittleEndian.Uint64(state))
+	return nil
+}
@@ -0,0 +1,126 @@
+package Structures
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeClock replaces the clock of rate limiters for the test, the returned function moves it forward.
+func fakeClock(t *testing.T) func(time.Duration) {
+	now := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC).UnixNano()
+	limiterClock = func() int64 {
+		return now
+	}
+	t.Cleanup(func() {
+		limiterClock = func() int64 {
+			return time.Now().UnixNano()
+		}
+	})
+	return func(d time.Duration) {
+		now += int64(d)
+	}
+}
+
+// allLimiters returns limiters of every kind which allow 10 tokens every 10 seconds.
+func allLimiters() map[string]RateLimiter {
+	return map[string]RateLimiter{
+		LimiterBucket: NewBucketWithRate(10, 10),
+		LimiterWindow: NewSlidingWindowLog(10, 10),
+		LimiterGCRA:   NewGCRA(10, 10),
+	}
+}
+
+func TestBucketRefillsContinuously(t *testing.T) {
+	advance := fakeClock(t)
+	b := NewBucketWithRate(10, 10)
+	if allowed, _ := b.Allow(10); !allowed {
+		t.Fatal("full bucket doesn't allow its capacity")
+	}
+	advance(2500 * time.Millisecond)
+	if tokens := b.Tokens(); tokens != 2 {
+		t.Errorf("%d tokens after 2.5s at 1 token per second, expected 2", tokens)
+	}
+	if retryAfter := b.RetryAfter(5); retryAfter != 2500*time.Millisecond {
+		t.Errorf("retry after %v for 5 tokens, expected 2.5s", retryAfter)
+	}
+	advance(time.Hour)
+	if tokens := b.Tokens(); tokens != 10 {
+		t.Errorf("%d tokens after an hour, expected the capacity 10", tokens)
+	}
+}
+
+func TestSlidingWindowLogRetryAfter(t *testing.T) {
+	advance := fakeClock(t)
+	w := NewSlidingWindowLog(10, 10)
+	w.Charge(4)
+	advance(3 * time.Second)
+	w.Charge(6)
+	advance(time.Second)
+	// The first request leaves the window 6s from now, which frees enough tokens for 3.
+	if retryAfter := w.RetryAfter(3); retryAfter != 6*time.Second {
+		t.Errorf("retry after %v for 3 tokens, expected 6s", retryAfter)
+	}
+	// All requests have to leave the window for 10 tokens.
+	if retryAfter := w.RetryAfter(10); retryAfter != 9*time.Second {
+		t.Errorf("retry after %v for 10 tokens, expected 9s", retryAfter)
+	}
+	advance(6 * time.Second)
+	if allowed, _ := w.Allow(4); !allowed {
+		t.Error("tokens of an expired request are not returned")
+	}
+}
+
+func TestGCRARetryAfter(t *testing.T) {
+	advance := fakeClock(t)
+	g := NewGCRA(10, 10)
+	if allowed, _ := g.Allow(10); !allowed {
+		t.Fatal("GCRA doesn't allow its capacity at once")
+	}
+	if retryAfter := g.RetryAfter(3); retryAfter != 3*time.Second {
+		t.Errorf("retry after %v for 3 tokens, expected 3s", retryAfter)
+	}
+	advance(1500 * time.Millisecond)
+	if tokens := g.Tokens(); tokens != 1 {
+		t.Errorf("%d tokens after 1.5s at 1 token per second, expected 1", tokens)
+	}
+	if retryAfter := g.RetryAfter(3); retryAfter != 1500*time.Millisecond {
+		t.Errorf("retry after %v for 3 tokens, expected 1.5s", retryAfter)
+	}
+}
+
+func TestLimitersDontDoubleBurstAtWindowBoundary(t *testing.T) {
+	for _, kind := range []string{LimiterBucket, LimiterWindow, LimiterGCRA} {
+		advance := fakeClock(t)
+		limiter := allLimiters()[kind]
+		// The capacity is used at the end of one window and again right after it.
+		advance(9900 * time.Millisecond)
+		if allowed, _ := limiter.Allow(10); !allowed {
+			t.Errorf("%s doesn't allow its capacity", kind)
+			continue
+		}
+		advance(200 * time.Millisecond)
+		allowed := 0
+		for limiter.RetryAfter(1) == 0 {
+			limiter.Charge(1)
+			allowed++
+		}
+		if allowed > 1 {
+			t.Errorf("%s allows %d more tokens 0.2s after its capacity was used", kind, allowed)
+		}
+	}
+}
+
+func TestLimitersRejectOverCapacity(t *testing.T) {
+	fakeClock(t)
+	for kind, limiter := range allLimiters() {
+		if retryAfter := limiter.RetryAfter(11); retryAfter != RetryNever {
+			t.Errorf("%s retry after %v for more tokens than the capacity", kind, retryAfter)
+		}
+		if allowed, retryAfter := limiter.Allow(11); allowed || retryAfter != RetryNever {
+			t.Errorf("%s allows more tokens than the capacity: %v, %v", kind, allowed, retryAfter)
+		}
+		if tokens := limiter.Tokens(); tokens != 10 {
+			t.Errorf("%s has %d tokens after a rejected request, expected 10", kind, tokens)
+		}
+	}
+}
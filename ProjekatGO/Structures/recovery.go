@@ -0,0 +1,123 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	TempSuffix        = ".tmp"
+	CompactionLogPath = "LSM/compaction.log"
+)
+
+// tableFiles names of the files every complete SSTable has.
+var tableFiles = []string{"sstable-data.dat", "sstable-index.dat", "sstable-summary.dat", "sstable-filter.dat",
+	"metadata.dat"}
+
+// crashPoint is called after every step of installing a compacted SSTable: "write", "log", "rename" and "remove" for
+// every input. Tests replace it to stop compaction the way a crash would.
+var crashPoint = func(step string) {}
+
+// isTableDir checks if the directory name belongs to an installed SSTable.
+func isTableDir(name string) bool {
+	return strings.HasPrefix(name, "SSTable") && !strings.HasSuffix(name, TempSuffix)
+}
+
+// isCompleteTable checks if all SSTable files exist in the given directory.
+func isCompleteTable(path string) bool {
+	for _, file := range tableFiles {
+		info, err := os.Stat(path + "/" + file)
+		if err != nil || !info.Mode().IsRegular() {
+			return false
+		}
+	}
+	return true
+}
+
+// syncClose flushes the given files to disk and closes them.
+func syncClose(files ...*os.File) error {
+	for _, file := range files {
+		err := file.Sync()
+		if err != nil {
+			return err
+		}
+		err = file.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeCompactionLog atomically writes the compaction log. First line is the output SSTable, the rest are the inputs.
+// Once the log is written the output is authoritative as soon as it is renamed to its final name.
+func writeCompactionLog(output string, inputs ...string) error {
+	tempPath := CompactionLogPath + TempSuffix
+	file, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteString(strings.Join(append([]string{output}, inputs...), "\n") + "\n")
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+	err = syncClose(file)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tempPath, CompactionLogPath)
+}
+
+// recoverCompaction finishes or rolls back the compaction that was interrupted by a crash. If the output was installed,
+// the inputs are removed, otherwise the inputs stay live and the partial output is removed.
+func recoverCompaction() {
+	_ = os.Remove(CompactionLogPath + TempSuffix)
+	data, err := ioutil.ReadFile(CompactionLogPath)
+	if err != nil {
+		return
+	}
+	lines := strings.Fields(string(data))
+	if len(lines) > 0 {
+		output := lines[0]
+		if isCompleteTable(output) {
+			for _, input := range lines[1:] {
+				fmt.Println("Removing compacted SSTable:", input)
+				_ = os.RemoveAll(input)
+			}
+		} else {
+			fmt.Println("Rolling back compaction of:", output)
+			_ = os.RemoveAll(output)
+			_ = os.RemoveAll(output + TempSuffix)
+		}
+	}
+	_ = os.Remove(CompactionLogPath)
+}
+
+// RecoverLSM is called on startup. It finishes an interrupted compaction and removes orphaned temporary and incomplete
+// SSTables from all levels.
+func RecoverLSM(config *Config) {
+	recoverCompaction()
+	for i := 1; i < int(config.LSMLevels); i++ {
+		levelPath := "LSM/C" + strconv.Itoa(i)
+		dirs, err := ioutil.ReadDir(levelPath)
+		if err != nil {
+			continue
+		}
+		for _, dir := range dirs {
+			if !dir.IsDir() {
+				continue
+			}
+			path := levelPath + "/" + dir.Name()
+			if !isTableDir(dir.Name()) || !isCompleteTable(path) {
+				fmt.Println("Removing incomplete SSTable:", path)
+				_ = os.RemoveAll(path)
+			}
+		}
+	}
+}
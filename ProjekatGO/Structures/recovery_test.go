@@ -0,0 +1,105 @@
+package Structures
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// crash is panicked with by crashPoint to stop compaction at the given step.
+type crash string
+
+// compactUntil runs a compaction of the first level that is stopped after the first time it reaches the given step, the
+// way a crash would stop it.
+func compactUntil(t *testing.T, store *Store, step string) {
+	crashPoint = func(current string) {
+		if current == step {
+			panic(crash(step))
+		}
+	}
+	defer func() {
+		crashPoint = func(string) {}
+		if r := recover(); r != nil {
+			if _, ok := r.(crash); !ok {
+				panic(r)
+			}
+		}
+	}()
+	CompactLevel(store, 1)
+	t.Fatal("compaction finished without reaching step", step)
+}
+
+// checkConsistent checks that no temporary files are left, every table is complete and all keys have their newest
+// values.
+func checkConsistent(t *testing.T, store *Store, expected map[string]string) {
+	if _, err := os.Stat(CompactionLogPath); err == nil {
+		t.Error("compaction log is left after recovery")
+	}
+	for i := 1; i < int(store.Config.LSMLevels); i++ {
+		dirs, _ := filepath.Glob("LSM/C" + strconv.Itoa(i) + "/*")
+		for _, dir := range dirs {
+			if !isTableDir(filepath.Base(dir)) || !isCompleteTable(dir) {
+				t.Error("incomplete table is left after recovery:", dir)
+			}
+		}
+	}
+	for key, value := range expected {
+		if v, ok := lookup(store, key); !ok || v != value {
+			t.Errorf("%s = %q, %v, expected %s", key, v, ok, value)
+		}
+	}
+}
+
+func TestRecoverInterruptedCompaction(t *testing.T) {
+	// Inputs stay live until the output is renamed, "remove" stops after only one of them is removed.
+	steps := []struct {
+		step   string
+		inputs bool
+	}{
+		{"write", true},
+		{"log", true},
+		{"rename", false},
+		{"remove", false},
+	}
+	expected := map[string]string{"a": "1", "b": "2", "x": "new"}
+	for _, s := range steps {
+		t.Run(s.step, func(t *testing.T) {
+			store := newTestStore(t)
+			s1 := flushTable(t, store, "a", "1", "x", "old")
+			s2 := flushTable(t, store, "b", "2", "x", "new")
+			compactUntil(t, store, s.step)
+			RecoverLSM(store.Config)
+			checkConsistent(t, store, expected)
+			for _, input := range []*SSTable{s1, s2} {
+				_, err := os.Stat(input.DirectoryPath)
+				if s.inputs && err != nil {
+					t.Error("input is removed although the output was not installed:", input.DirectoryPath)
+				} else if !s.inputs && err == nil {
+					t.Error("input is left although the output was installed:", input.DirectoryPath)
+				}
+			}
+			if installed := LevelTableCount(2) == 1; installed == s.inputs {
+				t.Error("output installed:", installed)
+			}
+		})
+	}
+}
+
+func TestRecoverPartialTables(t *testing.T) {
+	store := newTestStore(t)
+	flushTable(t, store, "a", "1")
+	for _, dir := range []string{"LSM/C1/SSTable2" + TempSuffix, "LSM/C1/SSTable3", "LSM/C2/SSTable1" + TempSuffix} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dir+"/sstable-data.dat", []byte("partial"), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	RecoverLSM(store.Config)
+	checkConsistent(t, store, map[string]string{"a": "1"})
+	if LevelTableCount(1) != 1 {
+		t.Errorf("%d tables on the first level, expected 1", LevelTableCount(1))
+	}
+}
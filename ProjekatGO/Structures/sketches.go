@@ -0,0 +1,204 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// SystemKeyPrefix keys starting with it are reserved for the store itself.
+	SystemKeyPrefix = "__sys:"
+	// SketchesKey key under which all sketches are checkpointed.
+	SketchesKey = SystemKeyPrefix + "sketches"
+
+	sketchEpsilon = 0.1
+	sketchDelta   = 0.1
+)
+
+// IsSystemKey returns true if the key is reserved for the store.
+func IsSystemKey(key string) bool {
+	return strings.HasPrefix(key, SystemKeyPrefix)
+}
+
+// Sketches holds all statistics of the store. They are checkpointed under SketchesKey through the WAL and memtable,
+// bypassing the caches, so a crash loses only operations since the last checkpoint.
+type Sketches struct {
+	config       *Config
+	CMS          FrequencySketch
+	HLL          *HyperLogLog
+	TopK         *TopK
+	Stats        *OperationStats
+	DistinctKeys *HLLRegistry
+	operations   int
+	checkpointAt time.Time
+}
+
+// NewSketches returns empty sketches configured by the given configuration.
+func NewSketches(config *Config) (*Sketches, error) {
+	cms, err := NewFrequencySketch(config, sketchEpsilon, sketchDelta)
+	if err != nil {
+		return nil, err
+	}
+	topK, err := NewTopK(config.TopKSize)
+	if err != nil {
+		return nil, err
+	}
+	stats := &OperationStats{config: config}
+	for _, sketch := range stats.sketches() {
+		*sketch, err = NewFrequencySketch(config, sketchEpsilon, sketchDelta)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &Sketches{
+		config:       config,
+		CMS:          cms,
+		HLL:          NewHyperLogLog(config.HLLPrecision),
+		TopK:         topK,
+		Stats:        stats,
+		DistinctKeys: NewHLLRegistry(config.HLLPrecision, config.HLLPrefixes),
+		checkpointAt: time.Now(),
+	}, nil
+}
+
+// Bytes returns all sketches serialized, every sketch as its name and data.
+func (s *Sketches) Bytes() []byte {
+	var bytes []byte
+	add := func(name string, data []byte) {
+		bytes = appendField(bytes, []byte(name))
+		bytes = appendField(bytes, data)
+	}
+	add("cms", s.CMS.bytes())
+	add("hll", s.HLL.bytes())
+	add("topk", s.TopK.bytes())
+	for i, sketch := range s.Stats.sketches() {
+		add("cms-"+operationSketches[i], (*sketch).bytes())
+	}
+	add("hll-prefixes", s.DistinctKeys.bytes())
+	return bytes
+}
+
+// SketchesFromBytes reads sketches written by Bytes. Sketches missing from the data start empty, and an error is
+// returned if any of them is invalid.
+func SketchesFromBytes(config *Config, bytes []byte) (*Sketches, error) {
+	s, err := NewSketches(config)
+	if err != nil {
+		return nil, err
+	}
+	curr := 0
+	for curr < len(bytes) {
+		name, n, err := readField(bytes[curr:])
+		if err != nil {
+			return nil, err
+		}
+		curr += n
+		data, n, err := readField(bytes[curr:])
+		if err != nil {
+			return nil, err
+		}
+		curr += n
+		if err := s.set(string(name), data); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+var errSketches = errors.New("sketches: invalid checkpoint")
+
+// readField reads a field written by appendField and returns the number of bytes read.
+func readField(bytes []byte) ([]byte, int, error) {
+	if len(bytes) < 8 {
+		return nil, 0, errSketches
+	}
+	size := binary.LittleEndian.Uint64(bytes[:8])
+	if size > uint64(len(bytes)-8) {
+		return nil, 0, errSketches
+	}
+	return bytes[8 : 8+size], 8 + int(size), nil
+}
+
+// set replaces the sketch with the given name by the serialized one.
+func (s *Sketches) set(name string, data []byte) error {
+	var err error
+	switch name {
+	case "cms":
+		s.CMS, err = frequencySketchFromBytes(s.config, data)
+	case "hll":
+		s.HLL = hllFromBytes(data)
+		if s.HLL == nil {
+			err = errSketches
+		}
+	case "topk":
+		s.TopK, err = topKFromBytes(data, s.config.TopKSize)
+	case "hll-prefixes":
+		s.DistinctKeys, err = hllRegistryFromConfig(s.config, data)
+	default:
+		for i, sketch := range s.Stats.sketches() {
+			if name == "cms-"+operationSketches[i] {
+				*sketch, err = frequencySketchFromBytes(s.config, data)
+			}
+		}
+	}
+	return err
+}
+
+// RecordGet updates sketches after a read of the key.
+func (s *Sketches) RecordGet(key string, found bool) {
+	s.CMS.Update(key)
+	s.TopK.Update(key)
+	s.Stats.Read(key, found)
+	s.operations++
+}
+
+// RecordPut updates sketches after a write of the key.
+func (s *Sketches) RecordPut(key string, value []byte) {
+	s.CMS.Update(key)
+	s.TopK.Update(key)
+	s.HLL.Add(value)
+	s.Stats.Write(key)
+	s.DistinctKeys.Add(key)
+	s.operations++
+}
+
+// RecordDelete updates sketches after a delete of the key.
+func (s *Sketches) RecordDelete(key string) {
+	s.CMS.Update(key)
+	s.TopK.Update(key)
+	s.Stats.Delete(key)
+	s.operations++
+}
+
+// CheckpointDue returns true if enough operations or time passed since the last checkpoint.
+func (s *Sketches) CheckpointDue() bool {
+	if s.operations == 0 {
+		return false
+	}
+	if s.config.SketchCheckpointOps > 0 && s.operations >= s.config.SketchCheckpointOps {
+		return true
+	}
+	interval := time.Duration(s.config.SketchCheckpointTime) * time.Second
+	return interval > 0 && time.Since(s.checkpointAt) >= interval
+}
+
+// Checkpointed has to be called after the sketches are written.
+func (s *Sketches) Checkpointed() {
+	s.operations = 0
+	s.checkpointAt = time.Now()
+}
+
+// Operations returns the number of operations since the last checkpoint.
+func (s *Sketches) Operations() int {
+	return s.operations
+}
+
+// Info prints out the number of operations since the last checkpoint.
+func (s *Sketches) Info() {
+	fmt.Println("Operations since checkpoint:", s.operations)
+	fmt.Println("Last checkpoint:", s.checkpointAt.Format(time.RFC3339))
+}
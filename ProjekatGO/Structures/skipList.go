@@ -314,8 +314,17 @@ func (sl *SkipList) Delete(key string) (*SkipListNode, error) {
 	return ret, nil
 }
 
-// Find finds a node with the given key in the SkipList.
+// Find finds a node with the given key in the SkipList, deleted nodes are not returned.
 func (sl *SkipList) Find(key string) *SkipListNode {
+	node := sl.FindDeleted(key)
+	if node != nil && string(node.value[0]) == "1" {
+		return nil
+	}
+	return node
+}
+
+// FindDeleted finds a node with the given key in the SkipList, even if it is deleted.
+func (sl *SkipList) FindDeleted(key string) *SkipListNode {
 	if sl.isEmpty() {
 		return nil
 	}
@@ -333,9 +342,6 @@ func (sl *SkipList) Find(key string) *SkipListNode {
 		}
 
 		if current.key == key {
-			if string(current.value[0]) == "1" {
-				return nil
-			}
 			return current
 		} else if key < current.key {
 			if prev != nil {
@@ -356,6 +362,25 @@ func (sl *SkipList) Find(key string) *SkipListNode {
 	}
 }
 
+// Range returns all nodes with keys in range [start, end], deleted nodes included.
+func (sl *SkipList) Range(start string, end string) []*SkipListNode {
+	var nodes []*SkipListNode
+	if sl.isEmpty() {
+		return nodes
+	}
+	current := sl.header[0]
+	for current != nil && current.key <= end {
+		if current.key >= start {
+			nodes = append(nodes, current)
+		}
+		if len(current.linkedNodes) == 0 {
+			break
+		}
+		current = current.linkedNodes[0]
+	}
+	return nodes
+}
+
 // Print prints out the SkipList data.
 func (sl *SkipList) Print() {
 	fmt.Println("Max height: ", sl.maxHeight)
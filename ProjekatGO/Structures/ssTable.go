@@ -5,8 +5,10 @@ package Structures
 import (
 	"encoding/binary"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -18,13 +20,24 @@ type SSTable struct {
 	SummaryPath   string
 	TOCPath       string
 	MerklePath    string
+	StatsPath     string
 }
 
-// FormSSTable forms a new SSTable with data from memtable.
-func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound string, level int) SSTable {
+// FormSSTable forms a new SSTable with data from memtable. The SSTable is written under a temporary name and renamed
+// once all files are on disk, so a crash never leaves a partially written SSTable behind. Writes go through the
+// given limiter with flush priority and the Merkle tree is hashed with the configured algorithm.
+func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound string, level int,
+	config *Config, limiter *IORateLimiter) SSTable {
+	return formSSTable("LSM/C"+strconv.Itoa(level), memtableData, lowerBound, upperBound, config, limiter)
+}
+
+// formSSTable forms a new SSTable in the given level directory, see FormSSTable.
+func formSSTable(levelPath string, memtableData []*SkipListNode, lowerBound string, upperBound string,
+	config *Config, limiter *IORateLimiter) SSTable {
 	s := SSTable{}
-	lsm := Lsm{}
-	lsm.SetAttributes(&s, level)
+	setAttributes(&s, levelPath)
+	tablePath := s.DirectoryPath
+	s.DirectoryPath = tablePath + TempSuffix
 
 	errDir := os.Mkdir(s.DirectoryPath, 0666)
 	if errDir != nil {
@@ -47,6 +60,21 @@ func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound str
 	if errMerkle != nil {
 		log.Fatal(errMerkle)
 	}
+	fileStats, errStats := os.OpenFile(s.DirectoryPath+"/"+s.StatsPath, os.O_WRONLY|os.O_CREATE, 0666)
+	if errStats != nil {
+		log.Fatal(errStats)
+	}
+	fileFilter, errFilter := os.OpenFile(s.DirectoryPath+"/"+s.FilterPath, os.O_WRONLY|os.O_CREATE, 0666)
+	if errFilter != nil {
+		log.Fatal(errFilter)
+	}
+
+	dataWriter := NewLimitedWriter(fileData, limiter, IOPriorityFlush)
+	indexWriter := NewLimitedWriter(fileIndex, limiter, IOPriorityFlush)
+	summaryWriter := NewLimitedWriter(fileSummary, limiter, IOPriorityFlush)
+	merkleWriter := NewLimitedWriter(fileMerkle, limiter, IOPriorityFlush)
+	statsWriter := NewLimitedWriter(fileStats, limiter, IOPriorityFlush)
+	filterWriter := NewLimitedWriter(fileFilter, limiter, IOPriorityFlush)
 
 	bf := NewBloomFilter(len(memtableData), 0.001)
 
@@ -71,7 +99,7 @@ func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound str
 	headerRecord = append(headerRecord, upperFix[:]...)
 	headerRecord = append(headerRecord, upperBoundBytes...)
 
-	_, errWriteSummary := fileSummary.Write(headerRecord)
+	_, errWriteSummary := summaryWriter.Write(headerRecord)
 	if errWriteSummary != nil {
 		log.Fatal(errWriteSummary)
 	}
@@ -80,6 +108,7 @@ func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound str
 	offsetSummary := 0
 
 	var contents []Content
+	stats := newTableStats()
 
 	for _, node := range memtableData {
 		key := node.Key()
@@ -138,7 +167,7 @@ func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound str
 		recordData = append(recordData, keyBytes...)
 		recordData = append(recordData, value[1:]...)
 
-		_, errWriteData := fileData.Write(recordData)
+		_, errWriteData := dataWriter.Write(recordData)
 		if errWriteData != nil {
 			log.Fatal(errWriteData)
 		}
@@ -147,16 +176,17 @@ func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound str
 		summaryRecord := indexRecord
 		indexRecord = append(indexRecord, offsetFix[:]...)
 
-		_, errWriteIndex := fileIndex.Write(indexRecord)
+		_, errWriteIndex := indexWriter.Write(indexRecord)
 		if errWriteIndex != nil {
 			log.Fatal(errWriteIndex)
 		}
 
 		offset += len(recordData)
+		stats.add(key, value[1:], string(tombstoneBytes) == "1", timestamp, len(recordData))
 
 		summaryRecord = append(summaryRecord, offsetSummaryFix[:]...)
 
-		_, errWriteSummary1 := fileSummary.Write(summaryRecord)
+		_, errWriteSummary1 := summaryWriter.Write(summaryRecord)
 		if errWriteSummary1 != nil {
 			log.Fatal(errWriteSummary1)
 		}
@@ -165,48 +195,87 @@ func FormSSTable(memtableData []*SkipListNode, lowerBound string, upperBound str
 
 	}
 
-	merkleTree, _ := NewTree(contents)
-	SerializeTree(merkleTree.root, fileMerkle, -1)
-	bf.Serialize(s.DirectoryPath + "/" + s.FilterPath)
+	merkleTree, errTree := NewTreeWithHash(contents, config.MerkleAlgorithm())
+	if errTree != nil {
+		log.Fatal(errTree)
+	}
+	errWriteMerkle := merkleTree.Serialize(merkleWriter)
+	if errWriteMerkle != nil {
+		log.Fatal(errWriteMerkle)
+	}
+	errWriteFilter := bf.Write(filterWriter)
+	if errWriteFilter != nil {
+		log.Fatal(errWriteFilter)
+	}
+	errWriteStats := stats.write(statsWriter)
+	if errWriteStats != nil {
+		log.Fatal(errWriteStats)
+	}
 
-	err := fileData.Close()
+	err := syncClose(fileData, fileIndex, fileSummary, fileMerkle, fileStats, fileFilter)
 	if err != nil {
 		return SSTable{}
 	}
-	err1 := fileIndex.Close()
-	if err1 != nil {
+	err = os.Rename(s.DirectoryPath, tablePath)
+	if err != nil {
 		return SSTable{}
 	}
-	err2 := fileSummary.Close()
-	if err2 != nil {
-		return SSTable{}
+	s.DirectoryPath = tablePath
+
+	return s
+}
+
+type Record struct {
+	Key       string
+	Value     []byte
+	Tombstone bool
+	Timestamp int64
+}
+
+// readFilter returns the BloomFilter of the SSTable, read through the block cache if it isn't nil.
+func readFilter(s *SSTable, cache *BlockCache) *BloomFilter {
+	if cache == nil {
+		return DeserializeFilter(s.DirectoryPath + "/" + s.FilterPath)
 	}
-	err3 := fileMerkle.Close()
-	if err3 != nil {
-		return SSTable{}
+	fileFilter, err := openTableFile(cache, s.DirectoryPath+"/"+s.FilterPath, true)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	bytes, err := io.ReadAll(fileFilter)
+	if err != nil {
+		fmt.Println(err)
+		return nil
 	}
+	return deserializeFilterBytes(bytes)
+}
 
-	return s
+// GetRecord returns record with the given key from SSTable. Files are read through the given block cache, if it isn't
+// nil.
+func GetRecord(s *SSTable, keyGiven string, cache *BlockCache) (string, []byte, bool) {
+	record, found := FindRecord(s, keyGiven, cache)
+	return record.Key, record.Value, found
 }
 
-// GetRecord returns record with the given key from SSTable.
-func GetRecord(s *SSTable, keyGiven string) (string, []byte, bool) {
-	bf := DeserializeFilter(s.DirectoryPath + "/" + s.FilterPath)
-	if !bf.Check(keyGiven) {
-		return "", nil, false
+// FindRecord returns record with the given key from SSTable, together with its tombstone and timestamp. Files are read
+// through the given block cache, if it isn't nil.
+func FindRecord(s *SSTable, keyGiven string, cache *BlockCache) (Record, bool) {
+	bf := readFilter(s, cache)
+	if bf == nil || !bf.Check(keyGiven) {
+		return Record{}, false
 	}
-	fileSummary, errSummary := os.OpenFile(s.DirectoryPath+"/"+s.SummaryPath, os.O_RDONLY, 0666)
+	fileSummary, errSummary := openTableFile(cache, s.DirectoryPath+"/"+s.SummaryPath, true)
 	if errSummary != nil {
 		log.Fatal(errSummary)
 	}
-	defer func(file *os.File) {
+	defer func(file tableFile) {
 		_ = file.Close()
 	}(fileSummary)
 
 	lowerBoundSizeBytes := make([]byte, KeySizeSize)
 	x, _ := fileSummary.Read(lowerBoundSizeBytes)
 	if x == 0 {
-		return "", nil, false
+		return Record{}, false
 	}
 
 	lowerBoundSize := binary.LittleEndian.Uint32(lowerBoundSizeBytes)
@@ -214,13 +283,13 @@ func GetRecord(s *SSTable, keyGiven string) (string, []byte, bool) {
 	_, _ = fileSummary.Read(lowerBoundBytes)
 	lowerBound := string(lowerBoundBytes)
 	if keyGiven < lowerBound {
-		return "", nil, false
+		return Record{}, false
 	}
 
 	upperBoundSizeBytes := make([]byte, KeySizeSize)
 	y, _ := fileSummary.Read(upperBoundSizeBytes)
 	if y == 0 {
-		return "", nil, false
+		return Record{}, false
 	}
 
 	upperBoundSize := binary.LittleEndian.Uint32(upperBoundSizeBytes)
@@ -228,7 +297,7 @@ func GetRecord(s *SSTable, keyGiven string) (string, []byte, bool) {
 	_, _ = fileSummary.Read(upperBoundBytes)
 	upperBound := string(upperBoundBytes)
 	if keyGiven > upperBound {
-		return "", nil, false
+		return Record{}, false
 	}
 
 	for {
@@ -246,13 +315,13 @@ func GetRecord(s *SSTable, keyGiven string) (string, []byte, bool) {
 			_, _ = fileSummary.Read(offsetIndexBytes)
 			offsetIndex := binary.LittleEndian.Uint64(offsetIndexBytes)
 
-			fileIndex, errIndex := os.OpenFile(s.DirectoryPath+"/"+s.IndexPath, os.O_RDONLY, 0666)
+			fileIndex, errIndex := openTableFile(cache, s.DirectoryPath+"/"+s.IndexPath, true)
 			if errIndex != nil {
 				log.Fatal(errIndex)
 			}
 			_, errSeek := fileIndex.Seek(int64(offsetIndex), 0)
 			if errSeek != nil {
-				return "", nil, false
+				return Record{}, false
 			}
 			keySizeBytesIndex := make([]byte, KeySizeSize)
 			_, _ = fileIndex.Read(keySizeBytesIndex)
@@ -266,16 +335,16 @@ func GetRecord(s *SSTable, keyGiven string) (string, []byte, bool) {
 
 			errCloseIndex := fileIndex.Close()
 			if errCloseIndex != nil {
-				return "", nil, false
+				return Record{}, false
 			}
 
-			fileData, errData := os.OpenFile(s.DirectoryPath+"/"+s.DataPath, os.O_RDONLY, 0666)
+			fileData, errData := openTableFile(cache, s.DirectoryPath+"/"+s.DataPath, false)
 			if errData != nil {
 				log.Fatal(errData)
 			}
 			_, errSeek2 := fileData.Seek(int64(offsetData), 0)
 			if errSeek2 != nil {
-				return "", nil, false
+				return Record{}, false
 			}
 
 			crcBytes := make([]byte, CrcSize)
@@ -303,20 +372,117 @@ func GetRecord(s *SSTable, keyGiven string) (string, []byte, bool) {
 
 			errData1 := fileData.Close()
 			if errData1 != nil {
-				return "", nil, false
+				return Record{}, false
 			}
 
-			return string(keyBytesData), valueBytes, true
+			return Record{Key: string(keyBytesData), Value: valueBytes, Tombstone: string(tombstoneBytes) == "1",
+				Timestamp: int64(binary.LittleEndian.Uint64(timestampBytes[TimestampSize-8:]))}, true
 
 		} else {
 			_, errSeek := fileSummary.Seek(OffsetSize, 1)
 			if errSeek != nil {
-				return "", nil, false
+				return Record{}, false
 			}
 		}
 
 	}
-	return "", nil, false
+	return Record{}, false
+}
+
+// ReadBounds returns the lower and upper bound keys from the SSTable summary header, false if the SSTable is empty.
+func ReadBounds(s *SSTable) (string, string, bool) {
+	fileSummary, errSummary := os.OpenFile(s.DirectoryPath+"/"+s.SummaryPath, os.O_RDONLY, 0666)
+	if errSummary != nil {
+		return "", "", false
+	}
+	defer func(file *os.File) {
+		_ = file.Close()
+	}(fileSummary)
+
+	bounds := make([]string, 2)
+	for i := range bounds {
+		sizeBytes := make([]byte, KeySizeSize)
+		n, _ := fileSummary.Read(sizeBytes)
+		if n == 0 {
+			return "", "", false
+		}
+		boundBytes := make([]byte, binary.LittleEndian.Uint32(sizeBytes))
+		_, _ = fileSummary.Read(boundBytes)
+		bounds[i] = string(boundBytes)
+	}
+	return bounds[0], bounds[1], true
+}
+
+// ScanTable returns all records from SSTable with keys in range [start, end], sorted by key. Files are read through
+// the given block cache, if it isn't nil.
+func ScanTable(s *SSTable, start string, end string, cache *BlockCache) []Record {
+	lower, upper, ok := ReadBounds(s)
+	if !ok || upper < start || lower > end {
+		return nil
+	}
+	fileSummary, errSummary := openTableFile(cache, s.DirectoryPath+"/"+s.SummaryPath, true)
+	if errSummary != nil {
+		log.Fatal(errSummary)
+	}
+	defer func(file tableFile) {
+		_ = file.Close()
+	}(fileSummary)
+	_, _ = fileSummary.Seek(int64(2*KeySizeSize+len(lower)+len(upper)), 0)
+
+	found := false
+	var offsetIndex uint64
+	for {
+		keySizeBytes := make([]byte, KeySizeSize)
+		n, _ := fileSummary.Read(keySizeBytes)
+		if n == 0 {
+			break
+		}
+		keyBytes := make([]byte, binary.LittleEndian.Uint32(keySizeBytes))
+		_, _ = fileSummary.Read(keyBytes)
+		offsetIndexBytes := make([]byte, OffsetSize)
+		_, _ = fileSummary.Read(offsetIndexBytes)
+		if string(keyBytes) >= start {
+			offsetIndex = binary.LittleEndian.Uint64(offsetIndexBytes)
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil
+	}
+
+	fileIndex, errIndex := openTableFile(cache, s.DirectoryPath+"/"+s.IndexPath, true)
+	if errIndex != nil {
+		log.Fatal(errIndex)
+	}
+	defer func(file tableFile) {
+		_ = file.Close()
+	}(fileIndex)
+	_, _ = fileIndex.Seek(int64(offsetIndex), 0)
+	keySizeBytesIndex := make([]byte, KeySizeSize)
+	_, _ = fileIndex.Read(keySizeBytesIndex)
+	_, _ = fileIndex.Seek(int64(binary.LittleEndian.Uint32(keySizeBytesIndex)), 1)
+	offsetDataBytes := make([]byte, OffsetSize)
+	_, _ = fileIndex.Read(offsetDataBytes)
+
+	fileData, errData := openTableFile(cache, s.DirectoryPath+"/"+s.DataPath, false)
+	if errData != nil {
+		log.Fatal(errData)
+	}
+	defer func(file tableFile) {
+		_ = file.Close()
+	}(fileData)
+	_, _ = fileData.Seek(int64(binary.LittleEndian.Uint64(offsetDataBytes)), 0)
+
+	var records []Record
+	for {
+		key, value, tombstone, timestamp, n := ReadRecord(fileData)
+		if n == 0 || key > end {
+			break
+		}
+		records = append(records, Record{Key: key, Value: value, Tombstone: tombstone == "1", Timestamp: timestamp})
+	}
+	return records
 }
 
 // Info prints out SSTable data.
@@ -327,10 +493,11 @@ func (s *SSTable) Info() {
 	fmt.Println(s.SummaryPath)
 	fmt.Println(s.FilterPath)
 	fmt.Println(s.MerklePath)
+	fmt.Println(s.StatsPath)
 }
 
 // ReadRecord reads one record from the given file. Used in compaction.
-func ReadRecord(fileData *os.File) (string, []byte, string, int64, int) {
+func ReadRecord(fileData io.Reader) (string, []byte, string, int64, int) {
 	crcBytes := make([]byte, CrcSize)
 	n, _ := fileData.Read(crcBytes)
 
@@ -354,12 +521,12 @@ func ReadRecord(fileData *os.File) (string, []byte, string, int64, int) {
 	valueBytes := make([]byte, valueSize)
 	_, _ = fileData.Read(valueBytes)
 
-	timestamp := binary.LittleEndian.Uint64(timestampBytes)
+	timestamp := binary.LittleEndian.Uint64(timestampBytes[TimestampSize-8:])
 	return string(keyBytesData), valueBytes, string(tombstoneBytes), int64(timestamp), n
 }
 
 // WriteRecord writes one record to the given file. Used in compaction.
-func WriteRecord(fileData *os.File, fileIndex *os.File, fileSummary *os.File, key string, value []byte, offset int,
+func WriteRecord(fileData io.Writer, fileIndex io.Writer, fileSummary io.Writer, key string, value []byte, offset int,
 	offsetSummary int, timestamp int64) (int, int) {
 	keyBytes := []byte(key)
 	crc := CRC32(append(keyBytes, value[1:]...))
@@ -0,0 +1,64 @@
+package Structures
+
+// Author: SV46/2020
+
+import "time"
+
+// Store holds the structures the whole store shares while it runs: compaction filters, the I/O rate limiter and the
+// block, negative and value caches. They are created from the configuration, which only holds configured values. The
+// value cache is set by its owner, compaction removes keys its filters dropped or changed from it.
+type Store struct {
+	Config        *Config
+	Filters       []CompactionFilter
+	IOLimiter     *IORateLimiter
+	BlockCache    *BlockCache
+	NegativeCache *NegativeCache
+	Cache         Cache
+}
+
+// NewStore returns a new Store with the structures given in configuration.
+func NewStore(config *Config) *Store {
+	store := &Store{Config: config, IOLimiter: NewIORateLimiter(config.IORate)}
+	if config.CompactionTTL > 0 {
+		store.AddCompactionFilter(NewTTLFilter(time.Duration(config.CompactionTTL)*time.Second,
+			config.CompactionTTLPrefix))
+	}
+	if config.BlockCacheSize > 0 {
+		store.BlockCache, _ = NewBlockCache(config.BlockCacheSize, config.PinIndexBlocks)
+	}
+	if config.NegativeCacheSize > 0 {
+		store.NegativeCache, _ = NewNegativeCache(config.NegativeCacheSize)
+	}
+	return store
+}
+
+// AddCompactionFilter adds a filter which is called during every compaction.
+func (store *Store) AddCompactionFilter(filter CompactionFilter) {
+	store.Filters = append(store.Filters, filter)
+}
+
+// invalidate removes the keys from the value cache, so their next read sees the compacted tables.
+func (store *Store) invalidate(keys []string) {
+	if store.Cache == nil {
+		return
+	}
+	for _, key := range keys {
+		store.Cache.RemoveFromCache(key)
+	}
+}
+
+// CacheWrite Remembers a written value, which starts with the tombstone byte, in the value cache. A put removes the key
+// from the negative cache, a delete adds it.
+func (store *Store) CacheWrite(key string, value []byte) {
+	if store.Cache != nil {
+		store.Cache.AddToCache(key, value)
+	}
+	if store.NegativeCache == nil {
+		return
+	}
+	if string(value[0]) == "1" {
+		store.NegativeCache.Add(key)
+	} else {
+		store.NegativeCache.Remove(key)
+	}
+}
@@ -0,0 +1,295 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+const (
+	DefaultStoreBuckets = 64
+)
+
+// StoreTree Merkle tree over all keys of a store. Keys are partitioned into a fixed number of buckets by their hash and
+// every leaf is the hash of one bucket. The tree is kept in an array, node i has children 2i and 2i+1, the root is
+// node 1 and the leafs start at the number of buckets.
+type StoreTree struct {
+	Path         string
+	buckets      int
+	algorithm    HashAlgorithm
+	hashFunction func(data []byte) []byte
+	nodes        [][]byte
+	records      []map[string]Record
+}
+
+// ReadStore builds the StoreTree of the store in the given directory from the newest version of every key in its
+// SSTables and WAL. The version with the greatest timestamp is the newest, like in Scan, records are read from the
+// oldest to the newest so a newer one wins a tie. Keys reserved for the store are left out, as they differ between
+// stores.
+func ReadStore(path string, config *Config) (*StoreTree, error) {
+	if _, err := os.Stat(filepath.Join(path, "LSM")); err != nil {
+		return nil, err
+	}
+	newest := make(map[string]Record)
+	keepNewest := func(record Record) {
+		if current, ok := newest[record.Key]; !ok || record.Timestamp >= current.Timestamp {
+			newest[record.Key] = record
+		}
+	}
+	for level := int(config.LSMLevels) - 1; level >= 1; level-- {
+		for _, table := range levelTablesAt(filepath.Join(path, "LSM", "C"+strconv.Itoa(level))) {
+			for _, record := range readTableRecords(table) {
+				keepNewest(record)
+			}
+		}
+	}
+	segments, err := filepath.Glob(filepath.Join(path, "Wal", "wal_*.log"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(segments)
+	for _, segment := range segments {
+		file, err := os.Open(segment)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range readRecords(file) {
+			keepNewest(record)
+		}
+		_ = file.Close()
+	}
+	var records []Record
+	for key, record := range newest {
+		if !IsSystemKey(key) {
+			records = append(records, record)
+		}
+	}
+	buckets := config.MerkleBuckets
+	if buckets <= 0 {
+		buckets = DefaultStoreBuckets
+	}
+	tree := NewStoreTree(records, buckets, config.MerkleAlgorithm())
+	tree.Path = path
+	return tree, nil
+}
+
+// readTableRecords reads all records of the SSTable.
+func readTableRecords(s *SSTable) []Record {
+	file, err := os.Open(s.DirectoryPath + "/" + s.DataPath)
+	if err != nil {
+		fmt.Println(err)
+		return nil
+	}
+	defer file.Close()
+	return readRecords(file)
+}
+
+// readRecords reads all records from the data file or WAL segment, they have the same format.
+func readRecords(file *os.File) []Record {
+	var records []Record
+	for {
+		key, value, tombstone, timestamp, n := ReadRecord(file)
+		if n == 0 {
+			return records
+		}
+		records = append(records, Record{Key: key, Value: value, Tombstone: tombstone == "1", Timestamp: timestamp})
+	}
+}
+
+// NewStoreTree returns a new StoreTree over the given records, hashed with the given algorithm. The number of buckets
+// is rounded up to a power of 2.
+func NewStoreTree(records []Record, buckets int, algorithm HashAlgorithm) *StoreTree {
+	if algorithm.Func() == nil {
+		algorithm = DefaultMerkleHash
+	}
+	buckets = 1 << uint(math.Ceil(math.Log2(float64(buckets))))
+	t := &StoreTree{buckets: buckets, algorithm: algorithm, hashFunction: algorithm.Func(),
+		nodes: make([][]byte, 2*buckets), records: make([]map[string]Record, buckets)}
+	for i := range t.records {
+		t.records[i] = make(map[string]Record)
+	}
+	for _, record := range records {
+		t.records[t.bucket(record.Key)][record.Key] = record
+	}
+	for i := 0; i < buckets; i++ {
+		t.nodes[buckets+i] = t.bucketHash(i)
+	}
+	for i := buckets - 1; i >= 1; i-- {
+		t.nodes[i] = hashInner(t.hashFunction, t.nodes[2*i], t.nodes[2*i+1])
+	}
+	return t
+}
+
+// bucket returns the bucket of the key.
+func (t *StoreTree) bucket(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(t.buckets))
+}
+
+// bucketHash hashes records of the bucket sorted by key, timestamps are left out as they differ between stores.
+func (t *StoreTree) bucketHash(i int) []byte {
+	var keys []string
+	for key := range t.records[i] {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	data := []byte{innerPrefix}
+	for _, key := range keys {
+		data = append(data, recordContent(t.records[i][key]).hashWith(t.hashFunction)...)
+	}
+	return t.hashFunction(data)
+}
+
+// recordContent returns the content of the record hashed by the StoreTree, the tombstone is hashed with the value.
+func recordContent(record Record) MyContent {
+	tombstone := byte('0')
+	if record.Tombstone {
+		tombstone = '1'
+	}
+	return MyContent{key: record.Key, value: append([]byte{tombstone}, record.Value...)}
+}
+
+// RootHash returns the hash of the root.
+func (t *StoreTree) RootHash() []byte {
+	return t.nodes[1]
+}
+
+// Diff returns keys whose newest versions differ between the stores, sorted. Only subtrees whose hashes differ are
+// visited.
+func Diff(a, b *StoreTree) ([]string, error) {
+	if a.buckets != b.buckets || a.algorithm != b.algorithm {
+		return nil, errors.New("merkle: stores have a different number of buckets or hash algorithm")
+	}
+	var keys []string
+	var visit func(i int)
+	visit = func(i int) {
+		if bytes.Equal(a.nodes[i], b.nodes[i]) {
+			return
+		}
+		if i < a.buckets {
+			visit(2 * i)
+			visit(2*i + 1)
+			return
+		}
+		bucket := i - a.buckets
+		for key, ra := range a.records[bucket] {
+			rb, ok := b.records[bucket][key]
+			if !ok || !sameRecord(ra, rb) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range b.records[bucket] {
+			if _, ok := a.records[bucket][key]; !ok {
+				keys = append(keys, key)
+			}
+		}
+	}
+	visit(1)
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// sameRecord checks if both records hold the same version of the key.
+func sameRecord(a, b Record) bool {
+	return a.Tombstone == b.Tombstone && bytes.Equal(a.Value, b.Value)
+}
+
+// RepairReport keys copied by Repair.
+type RepairReport struct {
+	Pulled []string
+	Pushed []string
+}
+
+// Repair copies the newer version of every key that differs between the stores to the store with the older version or
+// without the key, the local version is kept if both are written at the same time. Local records are written with the
+// given function, remote ones to the WAL and memtable of the remote store, which must not be open, through the limiter.
+func Repair(local, remote *StoreTree, config *Config, limiter *IORateLimiter,
+	put func(key string, value []byte, tombstone string)) (RepairReport, error) {
+	report := RepairReport{}
+	keys, err := Diff(local, remote)
+	if err != nil {
+		return report, err
+	}
+	writer, err := openStoreWriter(remote.Path, config, limiter)
+	if err != nil {
+		return report, err
+	}
+	for _, key := range keys {
+		bucket := local.bucket(key)
+		rl, inLocal := local.records[bucket][key]
+		rr, inRemote := remote.records[bucket][key]
+		tombstone := "0"
+		if inLocal && (!inRemote || rl.Timestamp >= rr.Timestamp) {
+			if rl.Tombstone {
+				tombstone = "1"
+			}
+			writer.write(key, rl.Value, tombstone)
+			report.Pushed = append(report.Pushed, key)
+		} else {
+			if rr.Tombstone {
+				tombstone = "1"
+			}
+			put(key, rr.Value, tombstone)
+			report.Pulled = append(report.Pulled, key)
+		}
+	}
+	return report, nil
+}
+
+// storeWriter writes to a store that is not open the same way the store does: to its WAL and memtable, which is
+// flushed to a new SSTable on the first level when it is full.
+type storeWriter struct {
+	path     string
+	wal      *Wal
+	memtable *Memtable
+	config   *Config
+	limiter  *IORateLimiter
+}
+
+// openStoreWriter loads the memtable of the store in the given directory from all its WAL segments, a flush removes
+// all of them.
+func openStoreWriter(path string, config *Config, limiter *IORateLimiter) (*storeWriter, error) {
+	w := &storeWriter{path: path, config: config, limiter: limiter,
+		wal: &Wal{DirectoryPath: filepath.Join(path, "Wal"), MaxSegmentCapacity: int(config.WalSize)}}
+	memtable, err := w.wal.ReadAllSegments(config)
+	if err != nil {
+		return nil, err
+	}
+	w.memtable = memtable
+	return w, nil
+}
+
+// write writes one record, tombstone is "1" for deleted records.
+func (w *storeWriter) write(key string, value []byte, tombstone string) {
+	w.wal.AddWalRecord(key, value, tombstone)
+	nodes, head, tail := w.memtable.Add(NewSkipListNode(key, append([]byte(tombstone), value...), nil))
+	if nodes == nil {
+		return
+	}
+	s := formSSTable(filepath.Join(w.path, "LSM", "C1"), nodes, head.Key(), tail.Key(), w.config, w.limiter)
+	if s.DirectoryPath == "" {
+		return
+	}
+	_ = w.wal.RemoveAllSegments()
+}
+
+// Info prints out RepairReport data.
+func (r *RepairReport) Info() {
+	fmt.Println("Pulled:", len(r.Pulled))
+	for _, key := range r.Pulled {
+		fmt.Println(key)
+	}
+	fmt.Println("Pushed:", len(r.Pushed))
+	for _, key := range r.Pushed {
+		fmt.Println(key)
+	}
+}
@@ -0,0 +1,155 @@
+package Structures
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newRemoteStore creates an empty store in the given directory next to the test store.
+func newRemoteStore(t *testing.T, config *Config, path string) {
+	for level := 1; level < int(config.LSMLevels); level++ {
+		if err := os.MkdirAll(filepath.Join(path, "LSM", "C"+strconv.Itoa(level)), 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// readStores reads both stores and returns keys that differ between them.
+func readStores(t *testing.T, config *Config, local, remote string) (*StoreTree, *StoreTree, []string) {
+	localTree, err := ReadStore(local, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteTree, err := ReadStore(remote, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := Diff(localTree, remoteTree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return localTree, remoteTree, keys
+}
+
+// storeValue returns the newest value of the key in the store tree, false if it doesn't exist or is deleted.
+func storeValue(tree *StoreTree, key string) (string, bool) {
+	record, ok := tree.records[tree.bucket(key)][key]
+	return string(record.Value), ok && !record.Tombstone
+}
+
+func TestRepairConverges(t *testing.T) {
+	store := newTestStore(t)
+	newRemoteStore(t, store.Config, "remote")
+	formTable(t, store, "LSM/C2", "a", "1", "x", "oldest", "d", "4")
+	flushTable(t, store, "x", "old", "b", "2")
+	flushTable(t, store, "x", "new", "d", "")
+	formTable(t, store, "remote/LSM/C2", "x", "old", "c", "3")
+	formTable(t, store, "remote/LSM/C1", "c", "33", "d", "4")
+
+	local, remote, keys := readStores(t, store.Config, ".", "remote")
+	expected := []string{"a", "b", "c", "d", "x"}
+	if len(keys) != len(expected) {
+		t.Fatalf("Diff = %v, expected %v", keys, expected)
+	}
+	localWriter, err := openStoreWriter(".", store.Config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Repair(local, remote, store.Config, nil, localWriter.write); err != nil {
+		t.Fatal(err)
+	}
+
+	local, remote, keys = readStores(t, store.Config, ".", "remote")
+	if len(keys) != 0 {
+		t.Fatalf("stores differ in %v after repair", keys)
+	}
+	for key, value := range map[string]string{"a": "1", "b": "2", "x": "new"} {
+		if v, ok := storeValue(remote, key); !ok || v != value {
+			t.Errorf("remote %s = %q, %v, expected %s", key, v, ok, value)
+		}
+	}
+	if v, ok := storeValue(local, "c"); !ok || v != "33" {
+		t.Errorf("local c = %q, %v, expected 33", v, ok)
+	}
+	if _, ok := storeValue(remote, "d"); ok {
+		t.Error("deleted key d is found in the remote store")
+	}
+}
+
+func TestRepairPushesNewestVersionAcrossLevels(t *testing.T) {
+	store := newTestStore(t)
+	newRemoteStore(t, store.Config, "remote")
+	flushTable(t, store, "x", "old")
+	// Timestamps have a resolution of a second.
+	time.Sleep(1100 * time.Millisecond)
+	formTable(t, store, "LSM/C2", "x", "new")
+	formTable(t, store, "remote/LSM/C1", "y", "1")
+
+	local, remote, _ := readStores(t, store.Config, ".", "remote")
+	if v, ok := storeValue(local, "x"); !ok || v != "new" {
+		t.Fatalf("local x = %q, %v, expected new", v, ok)
+	}
+	localWriter, err := openStoreWriter(".", store.Config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Repair(local, remote, store.Config, nil, localWriter.write); err != nil {
+		t.Fatal(err)
+	}
+	_, remote, keys := readStores(t, store.Config, ".", "remote")
+	if len(keys) != 0 {
+		t.Fatalf("stores differ in %v after repair", keys)
+	}
+	if v, ok := storeValue(remote, "x"); !ok || v != "new" {
+		t.Errorf("remote x = %q, %v, expected new", v, ok)
+	}
+}
+
+func TestRepairKeepsAllRemoteWalSegments(t *testing.T) {
+	store := newTestStore(t)
+	store.Config.WalSize = 2
+	store.Config.MemtableSize = 4
+	newRemoteStore(t, store.Config, "remote")
+	// The remote store was closed with 3 records in 2 WAL segments that are not flushed yet.
+	remoteWriter, err := openStoreWriter("remote", store.Config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"r1", "r2", "r3"} {
+		remoteWriter.write(key, []byte("remote"), "0")
+	}
+	if segments, _ := remoteWriter.wal.Segments(); len(segments) != 2 {
+		t.Fatalf("remote records are in %d WAL segments, expected 2", len(segments))
+	}
+	formTable(t, store, "LSM/C1", "l1", "local", "l2", "local", "l3", "local")
+
+	local, remote, _ := readStores(t, store.Config, ".", "remote")
+	localWriter, err := openStoreWriter(".", store.Config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pushed keys fill the remote memtable, the flush removes all WAL segments.
+	if _, err := Repair(local, remote, store.Config, nil, localWriter.write); err != nil {
+		t.Fatal(err)
+	}
+	if len(levelTablesAt("remote/LSM/C1")) == 0 {
+		t.Fatal("remote memtable is not flushed")
+	}
+	_, remote, keys := readStores(t, store.Config, ".", "remote")
+	for _, key := range []string{"r1", "r2", "r3"} {
+		if v, ok := storeValue(remote, key); !ok || v != "remote" {
+			t.Errorf("remote %s = %q, %v after the flush, expected remote", key, v, ok)
+		}
+	}
+	for _, key := range []string{"l1", "l2", "l3"} {
+		if v, ok := storeValue(remote, key); !ok || v != "local" {
+			t.Errorf("remote %s = %q, %v, expected local", key, v, ok)
+		}
+	}
+	if len(keys) != 0 {
+		t.Errorf("stores differ in %v after repair", keys)
+	}
+}
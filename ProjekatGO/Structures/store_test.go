@@ -0,0 +1,70 @@
+package Structures
+
+import "testing"
+
+// newCachedStore creates an empty test store with a value cache.
+func newCachedStore(t *testing.T) *Store {
+	store := newTestStore(t)
+	cache, err := NewCacheLRU(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Cache = cache
+	return store
+}
+
+func TestCacheWriteInvalidatesNegativeEntry(t *testing.T) {
+	store := newCachedStore(t)
+	// A get that found nothing remembers the key as missing.
+	store.NegativeCache.Add("a")
+	store.CacheWrite("a", []byte("01"))
+	if store.NegativeCache.Check("a") {
+		t.Error("put after a cached miss left the negative entry")
+	}
+	store.CacheWrite("a", []byte("1"))
+	if !store.NegativeCache.Check("a") {
+		t.Error("delete didn't add a negative entry")
+	}
+	store.CacheWrite("a", []byte("02"))
+	if store.NegativeCache.Check("a") {
+		t.Error("put after a delete left the negative entry")
+	}
+	if value, ok := store.Cache.GetFromCache("a"); !ok || string(value) != "02" {
+		t.Errorf("cached a = %q, %v, expected 02", value, ok)
+	}
+}
+
+// changeFilter changes the value of the given key to "changed".
+type changeFilter string
+
+func (f changeFilter) Filter(_ int, key string, _ []byte, _ int64) (FilterDecision, []byte) {
+	if key == string(f) {
+		return FilterChange, []byte("changed")
+	}
+	return FilterKeep, nil
+}
+
+func TestFilterPurgeInvalidatesCache(t *testing.T) {
+	store := newCachedStore(t)
+	flushTable(t, store, "a", "1", "session:1", "v")
+	flushTable(t, store, "b", "2", "z", "3")
+	for _, key := range []string{"a", "b", "session:1", "z"} {
+		value, _ := lookup(store, key)
+		store.CacheWrite(key, []byte("0"+value))
+	}
+	store.AddCompactionFilter(dropFilter("session:1"))
+	store.AddCompactionFilter(changeFilter("a"))
+	if _, err := CompactLevel(store, 1); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "session:1"} {
+		if value, ok := store.Cache.GetEntryFromCache(key); ok {
+			t.Errorf("%s filtered by compaction is left in the cache as %q", key, value)
+		}
+	}
+	for _, key := range []string{"b", "z"} {
+		if _, ok := store.Cache.GetEntryFromCache(key); !ok {
+			t.Errorf("%s kept by compaction is removed from the cache", key)
+		}
+	}
+}
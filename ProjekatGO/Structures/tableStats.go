@@ -0,0 +1,142 @@
+package Structures
+
+// Author: SV46/2020
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	// tableStatsPrecision precision of the HLL of keys, small tables keep it sparse.
+	tableStatsPrecision  = 10
+	tableStatsHeaderSize = 48
+)
+
+// TableStats statistics of one SSTable, written next to its other files when the table is formed or compacted.
+type TableStats struct {
+	Entries      uint64
+	Tombstones   uint64
+	RawBytes     uint64
+	EncodedBytes uint64
+	MinTimestamp int64
+	MaxTimestamp int64
+	keys         *HyperLogLog
+}
+
+// newTableStats returns empty statistics.
+func newTableStats() *TableStats {
+	return &TableStats{MinTimestamp: math.MaxInt64, MaxTimestamp: math.MinInt64,
+		keys: NewHyperLogLog(tableStatsPrecision)}
+}
+
+// add counts one record, encoded is its size in the data file.
+func (ts *TableStats) add(key string, value []byte, tombstone bool, timestamp int64, encoded int) {
+	ts.Entries++
+	if tombstone {
+		ts.Tombstones++
+	}
+	ts.RawBytes += uint64(len(key) + len(value))
+	ts.EncodedBytes += uint64(encoded)
+	if timestamp < ts.MinTimestamp {
+		ts.MinTimestamp = timestamp
+	}
+	if timestamp > ts.MaxTimestamp {
+		ts.MaxTimestamp = timestamp
+	}
+	ts.keys.Add([]byte(key))
+}
+
+// DistinctKeys estimates the number of distinct keys in the table.
+func (ts *TableStats) DistinctKeys() float64 {
+	return ts.keys.Estimate()
+}
+
+// TombstoneRatio returns the share of tombstones among the entries.
+func (ts *TableStats) TombstoneRatio() float64 {
+	if ts.Entries == 0 {
+		return 0
+	}
+	return float64(ts.Tombstones) / float64(ts.Entries)
+}
+
+// write writes the counters followed by the serialized HLL of keys.
+func (ts *TableStats) write(w io.Writer) error {
+	bytes := make([]byte, tableStatsHeaderSize)
+	binary.LittleEndian.PutUint64(bytes[0:8], ts.Entries)
+	binary.LittleEndian.PutUint64(bytes[8:16], ts.Tombstones)
+	binary.LittleEndian.PutUint64(bytes[16:24], ts.RawBytes)
+	binary.LittleEndian.PutUint64(bytes[24:32], ts.EncodedBytes)
+	binary.LittleEndian.PutUint64(bytes[32:40], uint64(ts.MinTimestamp))
+	binary.LittleEndian.PutUint64(bytes[40:48], uint64(ts.MaxTimestamp))
+	_, err := w.Write(append(bytes, ts.keys.bytes()...))
+	return err
+}
+
+// ReadTableStats reads statistics of the SSTable. Tables formed before statistics were written have them computed from
+// the data file.
+func ReadTableStats(s *SSTable) (*TableStats, error) {
+	bytes, err := os.ReadFile(s.DirectoryPath + "/" + s.StatsPath)
+	if os.IsNotExist(err) {
+		return computeTableStats(s)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(bytes) < tableStatsHeaderSize {
+		return nil, errors.New("sstable: invalid statistics of " + s.DirectoryPath)
+	}
+	ts := &TableStats{
+		Entries:      binary.LittleEndian.Uint64(bytes[0:8]),
+		Tombstones:   binary.LittleEndian.Uint64(bytes[8:16]),
+		RawBytes:     binary.LittleEndian.Uint64(bytes[16:24]),
+		EncodedBytes: binary.LittleEndian.Uint64(bytes[24:32]),
+		MinTimestamp: int64(binary.LittleEndian.Uint64(bytes[32:40])),
+		MaxTimestamp: int64(binary.LittleEndian.Uint64(bytes[40:48])),
+		keys:         hllFromBytes(bytes[tableStatsHeaderSize:]),
+	}
+	if ts.keys == nil {
+		return nil, errors.New("sstable: invalid statistics of " + s.DirectoryPath)
+	}
+	return ts, nil
+}
+
+// computeTableStats computes statistics by reading the whole data file.
+func computeTableStats(s *SSTable) (*TableStats, error) {
+	fileData, err := os.Open(s.DirectoryPath + "/" + s.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fileData.Close()
+	ts := newTableStats()
+	for {
+		key, value, tombstone, timestamp, n := ReadRecord(fileData)
+		if n == 0 {
+			break
+		}
+		ts.add(key, value, tombstone == "1", timestamp, recordSize(key, value))
+	}
+	return ts, nil
+}
+
+// recordSize returns the size of the record in the data file.
+func recordSize(key string, value []byte) int {
+	return CrcSize + TimestampSize + TombstoneSize + KeySizeSize + ValueSizeSize + len(key) + len(value)
+}
+
+// Info prints out TableStats data.
+func (ts *TableStats) Info() {
+	fmt.Println("Entries:", ts.Entries)
+	fmt.Println("Tombstones:", ts.Tombstones)
+	fmt.Println("Distinct keys:", math.Round(ts.DistinctKeys()))
+	fmt.Println("Raw bytes:", ts.RawBytes)
+	fmt.Println("Encoded bytes:", ts.EncodedBytes)
+	if ts.Entries > 0 {
+		fmt.Println("Min timestamp:", ts.MinTimestamp)
+		fmt.Println("Max timestamp:", ts.MaxTimestamp)
+	}
+}
@@ -3,51 +3,117 @@ package Structures
 // Author: SV38/2020
 
 import (
+	"encoding/binary"
+	"math"
 	"time"
 )
 
+// Bucket is a token bucket which is refilled continuously, capacity tokens every timeRate seconds.
 type Bucket struct {
 	capacity  int
-	tokens    int
+	tokens    float64
 	timeRate  int
 	timeStamp int64
 }
 
 // Tokens returns current available tokens.
 func (b *Bucket) Tokens() int {
-	return b.tokens
+	b.fill()
+	return int(math.Floor(b.tokens))
+}
+
+// Capacity returns the maximum number of tokens.
+func (b *Bucket) Capacity() int {
+	return b.capacity
 }
 
 // Remove removes one token from Bucket.
 func (b *Bucket) Remove() {
-	b.tokens--
+	b.Charge(1)
 }
 
-// fill fills Bucket and changes timeStamp.
-func (b *Bucket) fill() {
-	b.timeStamp = time.Now().Unix()
-	b.tokens = b.capacity
+// Charge removes n tokens from Bucket, tokens can go below zero when the cost is known only after the operation.
+func (b *Bucket) Charge(n int) {
+	b.fill()
+	b.tokens -= float64(n)
 }
 
-// Check checks if Bucket needs to be filled, and checks if there are available tokens.
-func (b *Bucket) Check() bool {
-	if time.Now().Unix()-b.timeStamp >= int64(b.timeRate) {
-		b.fill()
-		return true
+// rate returns the number of tokens added per second.
+func (b *Bucket) rate() float64 {
+	return float64(b.capacity) / float64(b.timeRate)
+}
+
+// fill adds tokens for the time passed since the last fill and changes timeStamp.
+func (b *Bucket) fill() {
+	now := limiterClock()
+	if b.timeRate <= 0 {
+		b.tokens = float64(b.capacity)
 	} else {
-		if b.tokens > 0 {
-			return true
+		b.tokens += float64(now-b.timeStamp) / float64(time.Second) * b.rate()
+		if b.tokens > float64(b.capacity) {
+			b.tokens = float64(b.capacity)
 		}
-		return false
 	}
+	b.timeStamp = now
+}
+
+// Check checks if there is an available token.
+func (b *Bucket) Check() bool {
+	return b.RetryAfter(1) == 0
+}
+
+// RetryAfter returns how long to wait until n tokens are available, 0 if they are available now.
+func (b *Bucket) RetryAfter(n int) time.Duration {
+	if n > b.capacity {
+		return RetryNever
+	}
+	b.fill()
+	missing := float64(n) - b.tokens
+	if missing <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(missing / b.rate() * float64(time.Second)))
+}
+
+// Allow removes n tokens if they are available, otherwise it returns how long to wait.
+func (b *Bucket) Allow(n int) (bool, time.Duration) {
+	retryAfter := b.RetryAfter(n)
+	if retryAfter > 0 {
+		return false, retryAfter
+	}
+	b.tokens -= float64(n)
+	return true, 0
+}
+
+// State returns tokens and the time of the last fill.
+func (b *Bucket) State() []byte {
+	state := make([]byte, 16)
+	binary.LittleEndian.PutUint64(state[:8], math.Float64bits(b.tokens))
+	binary.LittleEndian.PutUint64(state[8:], uint64(b.timeStamp))
+	return state
+}
+
+// SetState restores tokens and the time of the last fill, tokens for the time since then are added on the next use.
+func (b *Bucket) SetState(state []byte) error {
+	if len(state) != 16 {
+		return errLimiterState
+	}
+	b.tokens = math.Float64frombits(binary.LittleEndian.Uint64(state[:8]))
+	b.timeStamp = int64(binary.LittleEndian.Uint64(state[8:]))
+	return nil
 }
 
 // NewBucket returns a new Bucket.
 func NewBucket(config *Config) *Bucket {
+	return NewBucketWithRate(int(config.Threshold), config.TimeRate)
+}
+
+// NewBucketWithRate returns a new full Bucket with given capacity, which is refilled every timeRate seconds.
+func NewBucketWithRate(capacity int, timeRate int) *Bucket {
 	b := &Bucket{}
-	b.capacity = int(config.Threshold)
-	b.tokens = b.capacity
-	b.timeRate = config.TimeRate
-	b.timeStamp = time.Now().Unix()
+	b.capacity = capacity
+	b.tokens = float64(b.capacity)
+	b.timeRate = timeRate
+	b.timeStamp = limiterClock()
 	return b
 }
@@ -0,0 +1,181 @@
+package Structures
+
+// Author: SV38/2020
+
+import (
+	"container/heap"
+	"encoding/binary"
+	"errors"
+	"os"
+	"sort"
+)
+
+// HeavyHitter is a key with its estimated count. The real count is between Count-Error and Count.
+type HeavyHitter struct {
+	Key   string
+	Count uint64
+	Error uint64
+	index int
+}
+
+type hitterHeap []*HeavyHitter
+
+func (h hitterHeap) Len() int           { return len(h) }
+func (h hitterHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+func (h hitterHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+func (h *hitterHeap) Push(x interface{}) {
+	hitter := x.(*HeavyHitter)
+	hitter.index = len(*h)
+	*h = append(*h, hitter)
+}
+func (h *hitterHeap) Pop() interface{} {
+	old := *h
+	hitter := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return hitter
+}
+
+// TopK tracks the most frequent keys with the Space-Saving algorithm. It monitors at most size keys, kept in a
+// min-heap by count. A key that is not monitored replaces the one with the smallest count and takes over its count.
+type TopK struct {
+	size    int
+	hitters hitterHeap
+	keys    map[string]*HeavyHitter
+}
+
+// NewTopK returns a new TopK which monitors at most size keys.
+func NewTopK(size int) (*TopK, error) {
+	if size <= 0 {
+		return nil, errors.New("topk: size should be greater than 0")
+	}
+	return &TopK{size: size, keys: make(map[string]*HeavyHitter)}, nil
+}
+
+// Update counts one occurrence of the key.
+func (t *TopK) Update(key string) {
+	t.UpdateBy(key, 1)
+}
+
+// UpdateBy counts n occurrences of the key.
+func (t *TopK) UpdateBy(key string, n uint64) {
+	if hitter, ok := t.keys[key]; ok {
+		hitter.Count += n
+		heap.Fix(&t.hitters, hitter.index)
+		return
+	}
+	if len(t.hitters) < t.size {
+		hitter := &HeavyHitter{Key: key, Count: n}
+		heap.Push(&t.hitters, hitter)
+		t.keys[key] = hitter
+		return
+	}
+	hitter := t.hitters[0]
+	delete(t.keys, hitter.Key)
+	hitter.Key = key
+	hitter.Error = hitter.Count
+	hitter.Count += n
+	t.keys[key] = hitter
+	heap.Fix(&t.hitters, 0)
+}
+
+// TopK returns at most n most frequent keys, the most frequent first. Negative n returns no keys.
+func (t *TopK) TopK(n int) []HeavyHitter {
+	if n < 0 {
+		n = 0
+	}
+	hitters := make([]HeavyHitter, 0, len(t.hitters))
+	for _, hitter := range t.hitters {
+		hitters = append(hitters, *hitter)
+	}
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Count != hitters[j].Count {
+			return hitters[i].Count > hitters[j].Count
+		}
+		return hitters[i].Key < hitters[j].Key
+	})
+	if n < len(hitters) {
+		hitters = hitters[:n]
+	}
+	return hitters
+}
+
+// Serialize Serializes TopK structure to the given file.
+func (t *TopK) Serialize(fileName string) {
+	writeSketchFile(fileName, t.bytes())
+}
+
+// bytes returns size, number of monitored keys and every key with its count and error.
+func (t *TopK) bytes() []byte {
+	bytes := make([]byte, 8)
+	binary.LittleEndian.PutUint32(bytes[:4], uint32(t.size))
+	binary.LittleEndian.PutUint32(bytes[4:8], uint32(len(t.hitters)))
+	for _, hitter := range t.hitters {
+		el := make([]byte, 24)
+		binary.LittleEndian.PutUint64(el[:8], uint64(len(hitter.Key)))
+		binary.LittleEndian.PutUint64(el[8:16], hitter.Count)
+		binary.LittleEndian.PutUint64(el[16:24], hitter.Error)
+		bytes = append(bytes, el...)
+		bytes = append(bytes, hitter.Key...)
+	}
+	return bytes
+}
+
+// DeserializeTopK Deserializes TopK structure from the given file, it monitors at most size keys.
+func DeserializeTopK(fileName string, size int) (*TopK, error) {
+	bytes, err := os.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	return topKFromBytes(bytes, size)
+}
+
+// topKFromBytes reads TopK written by bytes. The size it was written with is ignored, the given one is used, and if it
+// is smaller only the most frequent keys are kept.
+func topKFromBytes(bytes []byte, size int) (*TopK, error) {
+	invalid := errors.New("topk: invalid serialized top keys")
+	if len(bytes) < 8 {
+		return nil, invalid
+	}
+	t, err := NewTopK(size)
+	if err != nil {
+		return nil, err
+	}
+	count := int(binary.LittleEndian.Uint32(bytes[4:8]))
+	curr := 8
+	var hitters []*HeavyHitter
+	for i := 0; i < count; i++ {
+		if curr+24 > len(bytes) {
+			return nil, invalid
+		}
+		keySize := int(binary.LittleEndian.Uint64(bytes[curr : curr+8]))
+		hitter := &HeavyHitter{
+			Count: binary.LittleEndian.Uint64(bytes[curr+8 : curr+16]),
+			Error: binary.LittleEndian.Uint64(bytes[curr+16 : curr+24]),
+		}
+		curr += 24
+		if keySize < 0 || curr+keySize > len(bytes) {
+			return nil, invalid
+		}
+		hitter.Key = string(bytes[curr : curr+keySize])
+		curr += keySize
+		hitters = append(hitters, hitter)
+	}
+	sort.Slice(hitters, func(i, j int) bool {
+		if hitters[i].Count != hitters[j].Count {
+			return hitters[i].Count > hitters[j].Count
+		}
+		return hitters[i].Key < hitters[j].Key
+	})
+	if len(hitters) > t.size {
+		hitters = hitters[:t.size]
+	}
+	for _, hitter := range hitters {
+		heap.Push(&t.hitters, hitter)
+		t.keys[hitter.Key] = hitter
+	}
+	return t, nil
+}
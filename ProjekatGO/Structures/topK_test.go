@@ -0,0 +1,57 @@
+package Structures
+
+import (
+	"strconv"
+	"testing"
+)
+
+// filledTopK returns a TopK of the given size where key i is counted i times, for i from 1 to keys.
+func filledTopK(t *testing.T, size int, keys int) *TopK {
+	topK, err := NewTopK(size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= keys; i++ {
+		topK.UpdateBy("key"+strconv.Itoa(i), uint64(i))
+	}
+	return topK
+}
+
+func TestTopKFromBytesUsesConfiguredSize(t *testing.T) {
+	data := filledTopK(t, 10, 10).bytes()
+	smaller, err := topKFromBytes(data, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hitters := smaller.TopK(10)
+	if len(hitters) != 3 {
+		t.Fatalf("%d keys are kept, expected the configured 3", len(hitters))
+	}
+	for i, hitter := range hitters {
+		if expected := "key" + strconv.Itoa(10-i); hitter.Key != expected || hitter.Count != uint64(10-i) {
+			t.Errorf("top %d is %s counted %d times, expected %s", i+1, hitter.Key, hitter.Count, expected)
+		}
+	}
+	smaller.Update("new")
+	if len(smaller.TopK(10)) != 3 {
+		t.Error("TopK grows over the configured size after loading")
+	}
+
+	larger, err := topKFromBytes(data, 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		larger.Update("new" + strconv.Itoa(i))
+	}
+	if n := len(larger.TopK(20)); n != 15 {
+		t.Errorf("%d keys are monitored, expected 15 with the configured size 20", n)
+	}
+}
+
+func TestTopKFromBytesRejectsTruncated(t *testing.T) {
+	data := filledTopK(t, 5, 5).bytes()
+	if _, err := topKFromBytes(data[:len(data)-1], 5); err == nil {
+		t.Error("truncated top keys are read")
+	}
+}
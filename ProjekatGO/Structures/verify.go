@@ -0,0 +1,229 @@
+package Structures
+
+// Author: SV14/2020
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ValueProof value of a key with the proof that it belongs to the SSTable with the given root.
+type ValueProof struct {
+	Key       string
+	Value     []byte
+	Table     string
+	Algorithm HashAlgorithm
+	Legacy    bool
+	Root      []byte
+	Proof     []ProofStep
+}
+
+// LeafMismatch leaf of the stored Merkle tree that differs from the one computed from the data file. Key is empty if
+// the data file has no record for the leaf.
+type LeafMismatch struct {
+	Leaf int
+	Key  string
+}
+
+// VerifyReport result of verifying one SSTable.
+type VerifyReport struct {
+	Path         string
+	Records      int
+	Algorithm    HashAlgorithm
+	StoredRoot   []byte
+	ComputedRoot []byte
+	Mismatches   []LeafMismatch
+}
+
+// OK returns true if the SSTable matches its Merkle tree.
+func (r *VerifyReport) OK() bool {
+	return bytes.Equal(r.StoredRoot, r.ComputedRoot) && len(r.Mismatches) == 0
+}
+
+// VerifySSTable rebuilds the Merkle tree of the SSTable in the given directory from its data file and compares it with
+// the tree in its metadata file. Leafs are compared only if the roots differ.
+func VerifySSTable(path string) (VerifyReport, error) {
+	s := tableFPath(path)
+	report := VerifyReport{Path: path}
+	fileMerkle, err := os.Open(s.DirectoryPath + "/" + s.MerklePath)
+	if err != nil {
+		return report, err
+	}
+	stored, err := DeserializeTree(fileMerkle)
+	_ = fileMerkle.Close()
+	if err != nil {
+		return report, err
+	}
+
+	fileData, err := os.Open(s.DirectoryPath + "/" + s.DataPath)
+	if err != nil {
+		return report, err
+	}
+	var contents []Content
+	for {
+		key, value, _, _, n := ReadRecord(fileData)
+		if n == 0 {
+			break
+		}
+		contents = append(contents, MyContent{key: key, value: value})
+	}
+	_ = fileData.Close()
+	report.Records = len(contents)
+	var computed *MerkleTree
+	if len(contents) > 0 {
+		algorithm := DefaultMerkleHash
+		legacy := false
+		if stored != nil {
+			algorithm = stored.Algorithm()
+			legacy = stored.Legacy()
+		}
+		computed, err = newTree(contents, algorithm, legacy)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	var storedLeafs, computedLeafs []*Node
+	if stored != nil {
+		report.Algorithm = stored.Algorithm()
+		report.StoredRoot = stored.Root()
+		storedLeafs = stored.leafs
+	}
+	if computed != nil {
+		report.ComputedRoot = computed.Root()
+		computedLeafs = computed.leafs
+	}
+	if bytes.Equal(report.StoredRoot, report.ComputedRoot) {
+		return report, nil
+	}
+	for i := 0; i < len(storedLeafs) || i < len(computedLeafs); i++ {
+		if i < len(storedLeafs) && i < len(computedLeafs) && bytes.Equal(storedLeafs[i].data, computedLeafs[i].data) {
+			continue
+		}
+		mismatch := LeafMismatch{Leaf: i}
+		if i < len(contents) {
+			mismatch.Key = contents[i].(MyContent).key
+		}
+		report.Mismatches = append(report.Mismatches, mismatch)
+	}
+	return report, nil
+}
+
+// GetWithProof returns the newest value of the key from SSTables with the proof that it belongs to the Merkle tree of
+// its SSTable. Values which are still in the memtable have no proof yet.
+func GetWithProof(key string, memtable *Memtable, config *Config, blockCache *BlockCache) (ValueProof, error) {
+	if memtable.SkipList().FindDeleted(key) != nil {
+		return ValueProof{}, errors.New("key " + key + " is not flushed to an SSTable yet")
+	}
+	lsm := Lsm{}
+	for _, table := range lsm.GetAll(int(config.LSMLevels)) {
+		record, f := FindRecord(table, key, blockCache)
+		if !f {
+			continue
+		}
+		if record.Tombstone {
+			break
+		}
+		tree, err := readTableTree(table)
+		if err != nil {
+			return ValueProof{}, err
+		}
+		proof, err := tree.Proof(key)
+		if err != nil {
+			return ValueProof{}, err
+		}
+		return ValueProof{Key: key, Value: record.Value, Table: table.DirectoryPath, Algorithm: tree.Algorithm(),
+			Legacy: tree.Legacy(), Root: tree.Root(), Proof: proof}, nil
+	}
+	return ValueProof{}, errors.New("key " + key + " doesn't exist")
+}
+
+// readTableTree reads the Merkle tree of the SSTable and indexes its leafs by the keys from the data file.
+func readTableTree(s *SSTable) (*MerkleTree, error) {
+	fileMerkle, err := os.Open(s.DirectoryPath + "/" + s.MerklePath)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := DeserializeTree(fileMerkle)
+	_ = fileMerkle.Close()
+	if err != nil {
+		return nil, err
+	}
+	if tree == nil {
+		return nil, errors.New("merkle: " + s.DirectoryPath + " has no tree")
+	}
+	fileData, err := os.Open(s.DirectoryPath + "/" + s.DataPath)
+	if err != nil {
+		return nil, err
+	}
+	defer fileData.Close()
+	var keys []string
+	for {
+		key, _, _, _, n := ReadRecord(fileData)
+		if n == 0 {
+			break
+		}
+		keys = append(keys, key)
+	}
+	tree.indexKeys(keys)
+	return tree, nil
+}
+
+// Verify checks the proof against the root.
+func (vp *ValueProof) Verify() bool {
+	return verifyProof(vp.Algorithm, vp.Legacy, vp.Root, vp.Key, vp.Value, vp.Proof)
+}
+
+// Info prints out ValueProof data.
+func (vp *ValueProof) Info() {
+	fmt.Println("Key:", vp.Key)
+	fmt.Println("Value:", string(vp.Value))
+	fmt.Println("SSTable:", vp.Table)
+	fmt.Println("Hash:", vp.Algorithm)
+	fmt.Println("Root:", hex.EncodeToString(vp.Root))
+	for i, step := range vp.Proof {
+		side := "right"
+		if step.Left {
+			side = "left"
+		}
+		fmt.Println("Proof", i, side+":", hex.EncodeToString(step.Hash))
+	}
+}
+
+// VerifyLSM verifies all SSTables, from the newest to the oldest.
+func VerifyLSM(config *Config) []VerifyReport {
+	lsm := Lsm{}
+	var reports []VerifyReport
+	for _, table := range lsm.GetAll(int(config.LSMLevels)) {
+		report, err := VerifySSTable(table.DirectoryPath)
+		if err != nil {
+			fmt.Println(table.DirectoryPath+":", err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// Info prints out VerifyReport data.
+func (r *VerifyReport) Info() {
+	fmt.Println(r.Path)
+	fmt.Println("Records:", r.Records)
+	if r.OK() {
+		fmt.Println("OK")
+		return
+	}
+	fmt.Println("Hash:", r.Algorithm)
+	fmt.Println("Stored root:", hex.EncodeToString(r.StoredRoot))
+	fmt.Println("Computed root:", hex.EncodeToString(r.ComputedRoot))
+	for _, mismatch := range r.Mismatches {
+		if mismatch.Key == "" {
+			fmt.Println("Leaf", mismatch.Leaf, "has no record")
+		} else {
+			fmt.Println("Leaf", mismatch.Leaf, "differs, key:", mismatch.Key)
+		}
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"math"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"time"
 )
@@ -73,7 +74,7 @@ func (w *Wal) AddWalRecord(key string, value []byte, tombstone string) {
 		w.NumOfActiveSegmentRecords = 0
 	}
 
-	file, err := os.OpenFile(w.DirectoryPath+"/"+w.ActiveSegmentPath, os.O_APPEND|os.O_CREATE, 0666)
+	file, err := os.OpenFile(w.DirectoryPath+"/"+w.ActiveSegmentPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -133,6 +134,13 @@ func IsEmptyDir(name string) (bool, error) {
 // ReadFromWalSegment reads data from given file, writes data to Mem table, and returns Mem table.
 func (w *Wal) ReadFromWalSegment(segmentPath string, config *Config) *Memtable {
 	skipList := NewSkipList(int(math.Log2(float64(config.MemtableSize))), []*SkipListNode{})
+	w.readSegment(segmentPath, skipList)
+	memtable := NewMemtable(int(config.MemtableSize), skipList)
+	return memtable
+}
+
+// readSegment adds all records of the given segment to the SkipList and returns the number of records.
+func (w *Wal) readSegment(segmentPath string, skipList *SkipList) int {
 	file, err := os.OpenFile(w.DirectoryPath+"/"+segmentPath, os.O_RDONLY, 0666)
 	if err != nil {
 		log.Fatal(err)
@@ -140,6 +148,7 @@ func (w *Wal) ReadFromWalSegment(segmentPath string, config *Config) *Memtable {
 	defer func(file *os.File) {
 		_ = file.Close()
 	}(file)
+	records := 0
 	for {
 		crcBytes := make([]byte, CrcSize)
 		n, _ := file.Read(crcBytes)
@@ -172,10 +181,41 @@ func (w *Wal) ReadFromWalSegment(segmentPath string, config *Config) *Memtable {
 		key := string(keyBytes)
 		node := NewSkipListNode(key, valueBytes, nil)
 		skipList.Add(node)
+		records++
+	}
+	return records
+}
 
+// Segments returns names of all WAL segments from the oldest to the newest.
+func (w *Wal) Segments() ([]string, error) {
+	paths, err := filepath.Glob(filepath.Join(w.DirectoryPath, "wal_*.log"))
+	if err != nil {
+		return nil, err
 	}
-	memtable := NewMemtable(int(config.MemtableSize), skipList)
-	return memtable
+	// Segment numbers have the same number of digits, so names sort by number.
+	sort.Strings(paths)
+	segments := make([]string, len(paths))
+	for i, path := range paths {
+		segments[i] = filepath.Base(path)
+	}
+	return segments, nil
+}
+
+// ReadAllSegments replays all WAL segments, from the oldest to the newest, into a new Mem table and continues writing
+// to the newest one. Records of segments written since the last flush are all in the Mem table, not only the ones of
+// the last segment.
+func (w *Wal) ReadAllSegments(config *Config) (*Memtable, error) {
+	segments, err := w.Segments()
+	if err != nil {
+		return nil, err
+	}
+	skipList := NewSkipList(int(math.Log2(float64(config.MemtableSize))), []*SkipListNode{})
+	w.SetDefaultParameters()
+	for _, segment := range segments {
+		w.ActiveSegmentPath = segment
+		w.NumOfActiveSegmentRecords = w.readSegment(segment, skipList)
+	}
+	return NewMemtable(int(config.MemtableSize), skipList), nil
 }
 
 // GetLastSegment is used to find the path to last WAL segment. Data from this segment will be written into Mem table
@@ -0,0 +1,195 @@
+package Structures
+
+// Author: SV11/2020
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+type StallReason int
+
+const (
+	StallNone StallReason = iota
+	StallL1Slowdown
+	StallPendingSlowdown
+	StallL1Stop
+	StallPendingStop
+)
+
+// String returns the name of the StallReason.
+func (r StallReason) String() string {
+	switch r {
+	case StallL1Slowdown:
+		return "l1-slowdown"
+	case StallPendingSlowdown:
+		return "pending-compaction-slowdown"
+	case StallL1Stop:
+		return "l1-stop"
+	case StallPendingStop:
+		return "pending-compaction-stop"
+	}
+	return "none"
+}
+
+// isStop checks if writers have to be blocked rather than delayed.
+func (r StallReason) isStop() bool {
+	return r == StallL1Stop || r == StallPendingStop
+}
+
+type WriteController struct {
+	store      *Store
+	config     *Config
+	lastReason StallReason
+	counts     map[StallReason]uint64
+	durations  map[StallReason]time.Duration
+}
+
+// NewWriteController returns a new WriteController of the store.
+func NewWriteController(store *Store) *WriteController {
+	return &WriteController{
+		store:     store,
+		config:    store.Config,
+		counts:    make(map[StallReason]uint64),
+		durations: make(map[StallReason]time.Duration),
+	}
+}
+
+// Reason returns the reason writers would be stalled right now, StallNone if they can proceed.
+func (wc *WriteController) Reason() StallReason {
+	l1Tables := LevelTableCount(1)
+	pending := PendingCompactionBytes(wc.config)
+	if wc.config.L1StopTables > 0 && l1Tables >= wc.config.L1StopTables {
+		return StallL1Stop
+	}
+	if wc.config.PendingStopBytes > 0 && pending >= wc.config.PendingStopBytes {
+		return StallPendingStop
+	}
+	if wc.config.L1SlowdownTables > 0 && l1Tables >= wc.config.L1SlowdownTables {
+		return StallL1Slowdown
+	}
+	if wc.config.PendingSlowdownBytes > 0 && pending >= wc.config.PendingSlowdownBytes {
+		return StallPendingSlowdown
+	}
+	return StallNone
+}
+
+// LastReason returns the reason of the last stall.
+func (wc *WriteController) LastReason() StallReason {
+	return wc.lastReason
+}
+
+// Count returns how many writes were stalled for the given reason.
+func (wc *WriteController) Count(reason StallReason) uint64 {
+	return wc.counts[reason]
+}
+
+// Duration returns the total time writes were stalled for the given reason.
+func (wc *WriteController) Duration(reason StallReason) time.Duration {
+	return wc.durations[reason]
+}
+
+// MaybeStall is called before every write. On a slowdown trigger the writer is delayed for the configured time and
+// levels over their table limit are compacted, so writers are not delayed forever when compaction is only run by hand.
+// On a stop trigger the writer is blocked until L1 and pending compaction bytes are under the stop triggers again.
+// While blocked, levels are compacted, and emptied into the next level if that is not enough. If a round of compaction
+// removes no table, the writer is let through, so it is never blocked forever.
+func (wc *WriteController) MaybeStall() {
+	reason := wc.Reason()
+	wc.lastReason = reason
+	if reason == StallNone {
+		return
+	}
+	start := time.Now()
+	if reason.isStop() {
+		for {
+			report := CompactAll(wc.store)
+			if !wc.Reason().isStop() {
+				break
+			}
+			for level := 1; level < int(wc.config.LSMLevels)-1; level++ {
+				report.add(compactLevelFully(wc.store, level))
+			}
+			if !wc.Reason().isStop() {
+				break
+			}
+			if len(report.TablesRemoved) == 0 {
+				fmt.Println("Compaction made no progress, write is not blocked any more.")
+				break
+			}
+			time.Sleep(wc.stopDelay())
+		}
+	} else {
+		time.Sleep(time.Duration(wc.config.SlowdownDelay) * time.Millisecond)
+		report := CompactAll(wc.store)
+		if len(report.TablesRemoved) == 0 && reason == StallL1Slowdown {
+			_, _ = CompactLevel(wc.store, 1)
+		}
+	}
+	wc.counts[reason]++
+	wc.durations[reason] += time.Since(start)
+}
+
+// stopDelay returns how long a blocked writer waits before compacting again.
+func (wc *WriteController) stopDelay() time.Duration {
+	if wc.config.SlowdownDelay > 0 {
+		return time.Duration(wc.config.SlowdownDelay) * time.Millisecond
+	}
+	return time.Millisecond
+}
+
+// Info prints out stall metrics.
+func (wc *WriteController) Info() {
+	fmt.Println("L1 tables:", LevelTableCount(1))
+	fmt.Println("Pending compaction bytes:", PendingCompactionBytes(wc.config))
+	fmt.Println("Current stall reason:", wc.Reason())
+	fmt.Println("Last stall reason:", wc.lastReason)
+	for _, reason := range []StallReason{StallL1Slowdown, StallPendingSlowdown, StallL1Stop, StallPendingStop} {
+		fmt.Println(reason, "stalls:", wc.counts[reason], "duration:", wc.durations[reason])
+	}
+}
+
+// LevelTableCount returns the number of SSTables on the given level.
+func LevelTableCount(level int) int {
+	dirs, err := ioutil.ReadDir("LSM/C" + strconv.Itoa(level))
+	if err != nil {
+		return 0
+	}
+	return len(tableDirs(dirs))
+}
+
+// PendingCompactionBytes returns the size of all SSTables on levels that reached their table limit, which is the amount
+// of data the next compaction has to rewrite.
+func PendingCompactionBytes(config *Config) uint64 {
+	var pending uint64
+	for i := 1; i < int(config.LSMLevels)-1; i++ {
+		if LevelTableCount(i) < config.LvlTables[i] {
+			continue
+		}
+		levelPath := "LSM/C" + strconv.Itoa(i)
+		dirs, err := ioutil.ReadDir(levelPath)
+		if err != nil {
+			continue
+		}
+		for _, dir := range tableDirs(dirs) {
+			pending += tableSize(levelPath + "/" + dir.Name())
+		}
+	}
+	return pending
+}
+
+// tableSize returns the size of all files of the SSTable in the given directory.
+func tableSize(path string) uint64 {
+	var size uint64
+	_ = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err == nil && info.Mode().IsRegular() {
+			size += uint64(info.Size())
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,86 @@
+package Structures
+
+import "testing"
+
+func TestMaybeStallStopCompactsUntilUnblocked(t *testing.T) {
+	store := newTestStore(t)
+	config := store.Config
+	config.LvlTables = map[int]int{1: 4, 2: 2}
+	config.L1SlowdownTables = 0
+	config.L1StopTables = 2
+	config.SlowdownDelay = 1
+	flushTable(t, store, "a", "1")
+	flushTable(t, store, "b", "2")
+	flushTable(t, store, "c", "3")
+	controller := NewWriteController(store)
+	controller.MaybeStall()
+	if controller.Count(StallL1Stop) != 1 {
+		t.Errorf("%d L1 stops recorded, expected 1", controller.Count(StallL1Stop))
+	}
+	if controller.Reason() != StallNone {
+		t.Errorf("writers are still stalled: %s", controller.Reason())
+	}
+	for key, expected := range map[string]string{"a": "1", "b": "2", "c": "3"} {
+		if value, ok := lookup(store, key); !ok || value != expected {
+			t.Errorf("%s = %q, %v, expected %s", key, value, ok, expected)
+		}
+	}
+}
+
+func TestMaybeStallPendingStopWithOddTable(t *testing.T) {
+	store := newTestStore(t)
+	config := store.Config
+	config.LvlTables = map[int]int{1: 2, 2: 2}
+	config.L1SlowdownTables = 0
+	config.L1StopTables = 0
+	config.PendingSlowdownBytes = 0
+	config.PendingStopBytes = 1
+	config.SlowdownDelay = 1
+	for _, key := range []string{"a", "b", "c", "d", "e"} {
+		flushTable(t, store, key, "1")
+	}
+	controller := NewWriteController(store)
+	controller.MaybeStall()
+	if controller.Count(StallPendingStop) != 1 {
+		t.Errorf("%d pending stops recorded, expected 1", controller.Count(StallPendingStop))
+	}
+	if PendingCompactionBytes(config) != 0 {
+		t.Errorf("%d pending compaction bytes left", PendingCompactionBytes(config))
+	}
+}
+
+func TestMaybeStallSlowdownCompacts(t *testing.T) {
+	store := newTestStore(t)
+	config := store.Config
+	config.LvlTables = map[int]int{1: 4, 2: 2}
+	config.L1SlowdownTables = 2
+	config.L1StopTables = 0
+	config.SlowdownDelay = 1
+	flushTable(t, store, "a", "1")
+	flushTable(t, store, "b", "2")
+	controller := NewWriteController(store)
+	controller.MaybeStall()
+	if controller.Count(StallL1Slowdown) != 1 {
+		t.Errorf("%d L1 slowdowns recorded, expected 1", controller.Count(StallL1Slowdown))
+	}
+	if LevelTableCount(1) >= 2 {
+		t.Errorf("%d tables on L1 after a slowdown, expected compaction to catch up", LevelTableCount(1))
+	}
+}
+
+func TestValidateRejectsStallingLimits(t *testing.T) {
+	for name, change := range map[string]func(c *Config){
+		"missing level limit": func(c *Config) { delete(c.LvlTables, 2) },
+		"level limit 1":       func(c *Config) { c.LvlTables[1] = 1 },
+		"l1 stop 1":           func(c *Config) { c.L1StopTables = 1 },
+	} {
+		config := defaultConfig()
+		change(config)
+		if config.validate() == nil {
+			t.Errorf("%s: config is valid", name)
+		}
+	}
+	if err := defaultConfig().validate(); err != nil {
+		t.Errorf("default config is not valid: %v", err)
+	}
+}
@@ -2,9 +2,11 @@ module ProjekatGO
 
 go 1.18
 
-require github.com/spaolacci/murmur3 v1.1.0
-
 require (
-	github.com/Workiva/go-datastructures v1.0.53 // indirect
-	gopkg.in/yaml.v2 v2.4.0 // indirect
+	github.com/Workiva/go-datastructures v1.0.53
+	github.com/spaolacci/murmur3 v1.1.0
+	golang.org/x/crypto v0.24.0
+	gopkg.in/yaml.v2 v2.4.0
 )
+
+require golang.org/x/sys v0.21.0 // indirect
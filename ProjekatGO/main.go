@@ -2,139 +2,473 @@ package main
 
 import (
 	"ProjekatGO/Structures"
+	"flag"
 	"fmt"
-	"math"
+	"log"
+	"os"
+	"sync"
+	"time"
 )
 
-// loadMemtable Loads memtable from WAL, if WAL is empty, returns empty memtable based on configuration.
+// loadMemtable Loads memtable from all WAL segments, if WAL is empty, returns empty memtable based on configuration.
 func loadMemtable(config *Structures.Config) (*Structures.Wal, *Structures.Memtable) {
-	directory := "Wal"
-	empty, _ := Structures.IsEmptyDir(directory)
 	w := Structures.Wal{
 		DirectoryPath:      "Wal",
 		MaxSegmentCapacity: int(config.WalSize),
 	}
-	var memtable *Structures.Memtable
-	if empty {
-		skipList := Structures.NewSkipList(int(math.Log2(float64(config.MemtableSize))), []*Structures.SkipListNode{})
-		w.SetDefaultParameters()
-		memtable = Structures.NewMemtable(int(config.MemtableSize), skipList)
-	} else {
-		memtable = w.ReadFromLastSegment(config)
+	memtable, err := w.ReadAllSegments(config)
+	if err != nil {
+		log.Fatal(err)
 	}
 	return &w, memtable
 }
 
-// createCache Creates cache with size given in configuration file.
-func createCache(config *Structures.Config) *Structures.CacheLRU {
-	cl, err := Structures.NewCacheLRU(int(config.CacheSize))
+// createCache Creates cache with policy, size and number of shards given in configuration file. If the cache can't be
+// created with the given policy, an LRU cache is used.
+func createCache(config *Structures.Config) Structures.Cache {
+	cl, err := Structures.NewCache(config)
+	if err == nil {
+		return cl
+	}
+	fmt.Println("Error while creating cache:", err)
+	fmt.Println("Using LRU cache.")
+	cLRU, err := Structures.NewCacheLRU(int(config.CacheSize))
 	if err != nil {
-		fmt.Println("Error while creating cache.")
+		log.Fatal(err)
+	}
+	return cLRU
+}
+
+type evictionCounter struct {
+	mutex  sync.Mutex
+	counts map[Structures.EvictionReason]int
+}
+
+// countEvictions Counts cache evictions by reason, if the cache supports eviction callbacks.
+func countEvictions(cache Structures.Cache) *evictionCounter {
+	counter := &evictionCounter{counts: make(map[Structures.EvictionReason]int)}
+	if cLRU, ok := cache.(*Structures.CacheLRU); ok {
+		cLRU.OnEvict(func(key string, value []byte, reason Structures.EvictionReason) {
+			counter.mutex.Lock()
+			counter.counts[reason]++
+			counter.mutex.Unlock()
+		})
+	}
+	return counter
+}
+
+// print Prints out eviction counts.
+func (counter *evictionCounter) print() {
+	counter.mutex.Lock()
+	defer counter.mutex.Unlock()
+	for _, reason := range []Structures.EvictionReason{Structures.EvictedCapacity, Structures.EvictedExpired,
+		Structures.EvictedRemoved} {
+		fmt.Println(reason, "evictions:", counter.counts[reason])
 	}
-	return cl
 }
 
-// putDel Puts a record in the memtable and cache based on key and value. When memtable is full it flushes.
+// putDel Puts a record in the memtable and cache based on key and value. When memtable is full it flushes. Writers are
+// delayed or blocked by the write controller while compaction is behind.
 func putDel(key string, value []byte, tombstone string, wal *Structures.Wal, memtable *Structures.Memtable,
-	cache *Structures.CacheLRU) {
+	controller *Structures.WriteController, store *Structures.Store) {
+	controller.MaybeStall()
 	value = append([]byte(tombstone), value...)
+	write(key, value, wal, memtable, store)
+	store.CacheWrite(key, value)
+}
+
+// write Writes a record, value starts with the tombstone byte, to the WAL and memtable. When memtable is full it
+// flushes.
+func write(key string, value []byte, wal *Structures.Wal, memtable *Structures.Memtable, store *Structures.Store) {
 	wal.AddWalRecord(key, value[1:], string(value[0]))
 	node := Structures.NewSkipListNode(key, value, nil)
 	skipListNodes, head, tail := memtable.Add(node)
-	cache.AddToCache(key, value)
 	// Flush
 	if skipListNodes != nil {
+		// WAL is removed only after the SSTable is installed, a crash in between replays the WAL.
+		s := Structures.FormSSTable(skipListNodes, head.Key(), tail.Key(), 1, store.Config, store.IOLimiter)
+		if s.DirectoryPath == "" {
+			return
+		}
 		err := wal.RemoveAllSegments()
 		if err != nil {
 			return
 		}
-		_ = Structures.FormSSTable(skipListNodes, head.Key(), tail.Key(), 1)
 	}
 }
 
 // put Calls putDel and sets tombstone to false.
 func put(key string, value []byte, wal *Structures.Wal, memtable *Structures.Memtable,
-	cache *Structures.CacheLRU) {
-	putDel(key, value, "0", wal, memtable, cache)
+	controller *Structures.WriteController, store *Structures.Store) {
+	putDel(key, value, "0", wal, memtable, controller, store)
 }
 
-// get Returns value for a given key. Path: memtable -> cache -> bloom -> summary -> index -> data
-func get(key string, memtable *Structures.Memtable, cache *Structures.CacheLRU, config *Structures.Config) []byte {
-	node := memtable.SkipList().Find(key)
+// get Returns value for a given key. Path: memtable -> cache -> negative cache -> bloom -> summary -> index -> data.
+// Keys which are deleted or not found are remembered in the negative cache.
+func get(key string, memtable *Structures.Memtable, store *Structures.Store) []byte {
+	cache := store.Cache
+	negative := store.NegativeCache
+	node := memtable.SkipList().FindDeleted(key)
 	if node != nil {
 		cache.AddToCache(key, node.Value())
+		if string(node.Value()[0]) == "1" {
+			recordNegativeHit(negative)
+			return nil
+		}
 		fmt.Println("Found in memtable.")
 		return node.Value()[1:]
 	}
-	value, _ := cache.GetFromCache(key)
+	value, _ := cache.GetEntryFromCache(key)
 	if value != nil {
+		if string(value[0]) == "1" {
+			recordNegativeHit(negative)
+			return nil
+		}
 		fmt.Println("Found in cache.")
 		return value[1:]
 	}
-	lsm := Structures.Lsm{}
-	table := lsm.GetLatest(int(config.LSMLevels))
-	_, value, f := Structures.GetRecord(table, key)
-	if f {
-		valueTomb := append([]byte("0"), value...)
+	if negative != nil && negative.Check(key) {
+		return nil
+	}
+	if record, f := findInTables(key, store.Config, store.BlockCache); f && !record.Tombstone {
+		valueTomb := append([]byte("0"), record.Value...)
 		cache.AddToCache(key, valueTomb)
 		fmt.Println("Found in SSTable.")
-		return value
+		return record.Value
+	}
+	if negative != nil {
+		negative.Add(key)
 	}
 	return nil
 }
 
+// findInTables Returns the newest record of the key from SSTables, newest tables are searched first.
+func findInTables(key string, config *Structures.Config, blockCache *Structures.BlockCache) (Structures.Record,
+	bool) {
+	lsm := Structures.Lsm{}
+	for _, table := range lsm.GetAll(int(config.LSMLevels)) {
+		if record, f := Structures.FindRecord(table, key, blockCache); f {
+			return record, true
+		}
+	}
+	return Structures.Record{}, false
+}
+
+// recordNegativeHit Counts a deleted key found before the negative cache.
+func recordNegativeHit(negative *Structures.NegativeCache) {
+	if negative != nil {
+		negative.RecordHit()
+	}
+}
+
+// scan Returns all records with keys in range [start, end]. Memtable records hide the ones from SSTables.
+func scan(start string, end string, memtable *Structures.Memtable, store *Structures.Store) []Structures.Record {
+	lsm := Structures.Lsm{}
+	var records []Structures.Record
+	nodes := memtable.SkipList().Range(start, end)
+	i := 0
+	for _, record := range lsm.Scan(store.Config, store.BlockCache, start, end) {
+		for i < len(nodes) && nodes[i].Key() < record.Key {
+			records = append(records, nodeRecord(nodes[i]))
+			i++
+		}
+		if i < len(nodes) && nodes[i].Key() == record.Key {
+			records = append(records, nodeRecord(nodes[i]))
+			i++
+			continue
+		}
+		records = append(records, record)
+	}
+	for ; i < len(nodes); i++ {
+		records = append(records, nodeRecord(nodes[i]))
+	}
+	return records
+}
+
+// nodeRecord Converts memtable node to a record.
+func nodeRecord(node *Structures.SkipListNode) Structures.Record {
+	return Structures.Record{Key: node.Key(), Value: node.Value()[1:], Tombstone: string(node.Value()[0]) == "1"}
+}
+
 // del Calls putDel and sets tombstone to true.
-func del(key string, wal *Structures.Wal, memtable *Structures.Memtable, cache *Structures.CacheLRU) {
-	putDel(key, []byte("000"), "1", wal, memtable, cache)
+func del(key string, wal *Structures.Wal, memtable *Structures.Memtable, controller *Structures.WriteController,
+	store *Structures.Store) {
+	putDel(key, []byte("000"), "1", wal, memtable, controller, store)
 }
 
-// tryAgain If a user made too many requests it needs to wait for a set time rate.
-func tryAgain() bool {
-	var cnt string
+// compact Asks which compaction to run (all levels, key range or a single level) and prints out its report.
+func compact(store *Structures.Store) {
+	var option string
+	fmt.Println("1 All")
+	fmt.Println("2 Key range")
+	fmt.Println("3 Level")
+	fmt.Print("Select compaction: ")
+	_, err := fmt.Scanln(&option)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	var report Structures.CompactionReport
+	if option == "1" {
+		report = Structures.CompactAll(store)
+	} else if option == "2" {
+		var start, end string
+		fmt.Print("Enter start key: ")
+		_, err = fmt.Scanln(&start)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Print("Enter end key: ")
+		_, err = fmt.Scanln(&end)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		report, err = Structures.CompactRange(store, start, end)
+	} else if option == "3" {
+		var level int
+		fmt.Print("Enter level: ")
+		_, err = fmt.Scanln(&level)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		report, err = Structures.CompactLevel(store, level)
+	} else {
+		fmt.Println("Invalid option!")
+		return
+	}
 	fmt.Println("-------------------")
-	fmt.Println("No more tokens.")
-	fmt.Println("1 Yes")
-	fmt.Println("2 No")
-	fmt.Print("Try again: ")
-	_, err := fmt.Scanln(&cnt)
 	if err != nil {
-		return false
+		fmt.Println(err)
+		return
 	}
+	report.Info()
 	fmt.Println("-------------------")
-	if cnt == "1" {
-		return true
-	} else {
-		return false
+}
+
+// tableStats Prints out statistics of every SSTable, from the newest to the oldest.
+func tableStats(config *Structures.Config) {
+	lsm := Structures.Lsm{}
+	for _, table := range lsm.GetAll(int(config.LSMLevels)) {
+		stats, err := Structures.ReadTableStats(table)
+		fmt.Println(table.DirectoryPath)
+		if err != nil {
+			fmt.Println(err)
+		} else {
+			stats.Info()
+		}
+		fmt.Println("-------------------")
+	}
+}
+
+// verify Verifies all SSTables against their Merkle trees and prints out the ones that don't match.
+func verify(config *Structures.Config) {
+	failed := 0
+	reports := Structures.VerifyLSM(config)
+	for _, report := range reports {
+		if !report.OK() {
+			report.Info()
+			fmt.Println("-------------------")
+			failed++
+		}
+	}
+	fmt.Println("Verified tables:", len(reports))
+	fmt.Println("Corrupt tables:", failed)
+	fmt.Println("-------------------")
+}
+
+// readStores Builds Merkle trees of this store and of the store in the given directory.
+func readStores(path string, config *Structures.Config) (*Structures.StoreTree, *Structures.StoreTree, error) {
+	local, err := Structures.ReadStore(".", config)
+	if err != nil {
+		return nil, nil, err
+	}
+	remote, err := Structures.ReadStore(path, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return local, remote, nil
+}
+
+// diff Prints out keys whose newest versions differ between this store and the store in the given directory.
+func diff(path string, config *Structures.Config) {
+	local, remote, err := readStores(path, config)
+	if err != nil {
+		fmt.Println(err)
+		return
 	}
+	keys, err := Structures.Diff(local, remote)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	for _, key := range keys {
+		fmt.Println(key)
+	}
+	fmt.Println("Divergent keys:", len(keys))
 }
 
-// loadCMSHLL Loads CMS and HLL structures from default path ("CMS_HLL/...")
-func loadCMSHLL() (*Structures.CountMinSketch, *Structures.HyperLogLog) {
-	var cms *Structures.CountMinSketch
-	var hll *Structures.HyperLogLog
-	empty, _ := Structures.IsEmptyDir("CMS_HLL")
-	if empty {
-		cms, _ = Structures.NewCMSWithEstimates(0.1, 0.1)
-		hll = Structures.NewHyperLogLog(8)
+// repair Copies newer versions of divergent keys between this store and the store in the given directory, which must
+// not be open. Records of this store are written with put.
+func repair(path string, store *Structures.Store, put func(key string, value []byte, tombstone string)) {
+	local, remote, err := readStores(path, store.Config)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	report, err := Structures.Repair(local, remote, store.Config, store.IOLimiter, put)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	report.Info()
+}
+
+// rejected Prints out how long the client has to wait before the operation can be retried.
+func rejected(retryAfter time.Duration) {
+	fmt.Println("-------------------")
+	fmt.Println("No more tokens, retry after", retryAfter.Round(time.Millisecond))
+	fmt.Println("-------------------")
+}
+
+// loadSketches Loads sketches checkpointed in the store. If there is no checkpoint, sketches are migrated from the old
+// files in "CMS_HLL", a corrupt checkpoint is reported and sketches start empty.
+func loadSketches(memtable *Structures.Memtable, config *Structures.Config,
+	blockCache *Structures.BlockCache) *Structures.Sketches {
+	var value []byte
+	if node := memtable.SkipList().FindDeleted(Structures.SketchesKey); node != nil {
+		value = node.Value()
+	} else if record, f := findInTables(Structures.SketchesKey, config, blockCache); f && !record.Tombstone {
+		value = append([]byte("0"), record.Value...)
+	}
+	if value != nil && string(value[0]) == "0" {
+		sketches, err := Structures.SketchesFromBytes(config, value[1:])
+		if err == nil {
+			return sketches
+		}
+		fmt.Println("Sketch checkpoint is corrupt, sketches start empty:", err)
 	} else {
-		cms = Structures.DeserializeCMS("CMS_HLL/cms.dat")
-		hll = Structures.DeserializeHLL("CMS_HLL/hll.dat")
+		sketches, err := loadSketchFiles(config)
+		if err == nil {
+			return sketches
+		}
+		fmt.Println(err)
+	}
+	sketches, err := Structures.NewSketches(config)
+	if err != nil {
+		log.Fatal(err)
 	}
-	return cms, hll
+	return sketches
 }
 
-// menu Main menu of the project.
-func menu() {
-	config := Structures.NewConfig("configuration.yaml")
+// loadSketchFiles Loads sketches from the files in "CMS_HLL" written before they were kept in the store. CMS type is
+// given in configuration.
+func loadSketchFiles(config *Structures.Config) (*Structures.Sketches, error) {
+	sketches, err := Structures.NewSketches(config)
+	if err != nil {
+		return nil, err
+	}
+	// CMS_HLL also holds other files, so each structure is loaded only if its own file exists.
+	sketches.CMS, err = Structures.LoadFrequencySketch(config, Structures.FrequencySketchPath(config, "CMS_HLL"), 0.1,
+		0.1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat("CMS_HLL/hll.dat"); err == nil {
+		if hll := Structures.DeserializeHLL("CMS_HLL/hll.dat"); hll != nil {
+			sketches.HLL = hll
+		}
+	}
+	if topK, err := Structures.DeserializeTopK("CMS_HLL/topk.dat", config.TopKSize); err == nil {
+		sketches.TopK = topK
+	} else if !os.IsNotExist(err) {
+		fmt.Println(err)
+	}
+	sketches.DistinctKeys = Structures.LoadHLLRegistry(config, "CMS_HLL/hll-prefixes.dat")
+	sketches.Stats, err = Structures.LoadOperationStats(config, "CMS_HLL", 0.1, 0.1)
+	if err != nil {
+		return nil, err
+	}
+	return sketches, nil
+}
+
+// checkpoint Writes sketches to the WAL and memtable under the reserved key. The record bypasses the caches and doesn't
+// take a place of user records in the memtable.
+func checkpoint(sketches *Structures.Sketches, wal *Structures.Wal, memtable *Structures.Memtable,
+	store *Structures.Store) {
+	write(Structures.SketchesKey, append([]byte("0"), sketches.Bytes()...), wal, memtable, store)
+	sketches.Checkpointed()
+}
+
+// readKey Reads a key from standard input, keys reserved for the store are rejected.
+func readKey(key *string) (bool, error) {
+	fmt.Print("Enter key: ")
+	_, err := fmt.Scanln(key)
+	if err != nil {
+		return false, err
+	}
+	if Structures.IsSystemKey(*key) {
+		fmt.Println("-------------------")
+		fmt.Println("Keys starting with", Structures.SystemKeyPrefix, "are reserved.")
+		fmt.Println("-------------------")
+		return false, nil
+	}
+	return true, nil
+}
+
+// readOption Reads the menu option from standard input, idle is called every interval while it waits. Standard input
+// is read by one goroutine at a time, the option is read before any other input of the operation.
+func readOption(interval time.Duration, idle func()) (string, error) {
+	type input struct {
+		option string
+		err    error
+	}
+	read := make(chan input, 1)
+	go func() {
+		var option string
+		_, err := fmt.Scanln(&option)
+		read <- input{option: option, err: err}
+	}()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case in := <-read:
+			return in.option, in.err
+		case <-ticker.C:
+			idle()
+		}
+	}
+}
+
+// menu Main menu of the project, operations are charged to the token bucket of the given client.
+func menu(client string) {
+	config, err := Structures.NewConfig("configuration.yaml")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	lsm := Structures.Lsm{}
 	lsm.GenerateLevels(config)
+	Structures.RecoverLSM(config)
+	store := Structures.NewStore(config)
 	wal, memtable := loadMemtable(config)
-	cache := createCache(config)
-	bucket := Structures.NewBucket(config)
-	cms, hll := loadCMSHLL()
+	store.Cache = createCache(config)
+	evictions := countEvictions(store.Cache)
+	limiter := Structures.LoadClientLimiter(config, Structures.LimiterStatePath)
+	sketches := loadSketches(memtable, config, store.BlockCache)
+	controller := Structures.NewWriteController(store)
+	// Sketches are checkpointed between operations, also while the menu waits for the next one.
+	checkpointDue := func() {
+		if sketches.CheckpointDue() {
+			checkpoint(sketches, wal, memtable, store)
+		}
+	}
 	for {
+		checkpointDue()
 		var key string
-		var option string
 		fmt.Println("1 Get")
 		fmt.Println("2 Put")
 		fmt.Println("3 Delete")
@@ -142,24 +476,41 @@ func menu() {
 		fmt.Println("5 Key frequency")
 		fmt.Println("6 Distinct values")
 		fmt.Println("7 Close")
+		fmt.Println("8 Write stalls")
+		fmt.Println("9 I/O rate")
+		fmt.Println("10 Scan")
+		fmt.Println("11 Block cache")
+		fmt.Println("12 Cache evictions")
+		fmt.Println("13 Negative cache")
+		fmt.Println("14 Tokens")
+		fmt.Println("15 Top keys")
+		fmt.Println("16 Distinct keys")
+		fmt.Println("17 Sketch checkpoint")
+		fmt.Println("18 Table stats")
+		fmt.Println("19 Verify")
+		fmt.Println("20 Get with proof")
+		fmt.Println("21 Diff")
+		fmt.Println("22 Repair")
 		fmt.Print("Select option: ")
-		_, err := fmt.Scanln(&option)
+		option, err := readOption(time.Second, checkpointDue)
 		if err != nil {
 			fmt.Println(err)
 			return
 		}
 		if option == "1" {
-			if bucket.Check() {
-				fmt.Print("Enter key: ")
-				_, err := fmt.Scanln(&key)
+			if retryAfter := limiter.Check(client, Structures.OpGet, 1); retryAfter == 0 {
+				ok, err := readKey(&key)
 				if err != nil {
 					fmt.Println(err)
 					return
 				}
+				if !ok {
+					continue
+				}
 				fmt.Println("-------------------")
-				value := get(key, memtable, cache, config)
-				cms.Update(key)
-				bucket.Remove()
+				value := get(key, memtable, store)
+				sketches.RecordGet(key, value != nil)
+				limiter.Charge(client, Structures.OpGet, 1)
 
 				if value == nil {
 					fmt.Println("Key doesn't exist.")
@@ -169,23 +520,20 @@ func menu() {
 				}
 				fmt.Println("-------------------")
 			} else {
-				again := tryAgain()
-				if again {
-					continue
-				} else {
-					break
-				}
+				rejected(retryAfter)
 			}
 
 		} else if option == "2" {
-			if bucket.Check() {
+			if retryAfter := limiter.Check(client, Structures.OpPut, 1); retryAfter == 0 {
 				var value []byte
-				fmt.Print("Enter key: ")
-				_, err := fmt.Scanln(&key)
+				ok, err := readKey(&key)
 				if err != nil {
 					fmt.Println(err)
 					return
 				}
+				if !ok {
+					continue
+				}
 				fmt.Print("Enter value: ")
 				_, err = fmt.Scanln(&value)
 				if err != nil {
@@ -193,49 +541,35 @@ func menu() {
 					return
 				}
 				fmt.Println("-------------------")
-				put(key, value, wal, memtable, cache)
-				cms.Update(key)
-				hll.Add(value)
-				bucket.Remove()
+				put(key, value, wal, memtable, controller, store)
+				sketches.RecordPut(key, value)
+				limiter.Charge(client, Structures.OpPut, 1)
 			} else {
-				again := tryAgain()
-				if again {
-					continue
-				} else {
-					break
-				}
+				rejected(retryAfter)
 			}
 		} else if option == "3" {
-			if bucket.Check() {
-				fmt.Print("Enter key: ")
-				_, err := fmt.Scanln(&key)
+			if retryAfter := limiter.Check(client, Structures.OpDelete, 1); retryAfter == 0 {
+				ok, err := readKey(&key)
 				if err != nil {
 					fmt.Println(err)
 					return
 				}
-				fmt.Println("-------------------")
-				del(key, wal, memtable, cache)
-				cms.Update(key)
-				bucket.Remove()
-			} else {
-				again := tryAgain()
-				if again {
+				if !ok {
 					continue
-				} else {
-					break
 				}
+				fmt.Println("-------------------")
+				del(key, wal, memtable, controller, store)
+				sketches.RecordDelete(key)
+				limiter.Charge(client, Structures.OpDelete, 1)
+			} else {
+				rejected(retryAfter)
 			}
 		} else if option == "4" {
-			if bucket.Check() {
-				Structures.CompactAll(config)
-				bucket.Remove()
+			if retryAfter := limiter.Check(client, Structures.OpCompact, 1); retryAfter == 0 {
+				compact(store)
+				limiter.Charge(client, Structures.OpCompact, 1)
 			} else {
-				again := tryAgain()
-				if again {
-					continue
-				} else {
-					break
-				}
+				rejected(retryAfter)
 			}
 		} else if option == "5" {
 			fmt.Print("Enter key: ")
@@ -244,24 +578,183 @@ func menu() {
 				fmt.Println(err)
 				return
 			}
-			fmt.Println(key, "frequency:", cms.Estimate(key))
+			fmt.Println(key, "frequency:", sketches.CMS.Estimate(key))
+			sketches.Stats.KeyStats(key).Info()
 			fmt.Println("-------------------")
 		} else if option == "6" {
-			fmt.Println("Distinct values:", hll.Estimate())
+			fmt.Println("Distinct values:", sketches.HLL.Estimate())
 			fmt.Println("-------------------")
 		} else if option == "7" {
 			fmt.Println("-------------------")
 			break
 
+		} else if option == "8" {
+			controller.Info()
+			fmt.Println("-------------------")
+		} else if option == "9" {
+			store.IOLimiter.Info()
+			var rate int64
+			fmt.Print("Enter new rate (bytes/s, 0 unlimited): ")
+			_, err := fmt.Scanln(&rate)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			store.IOLimiter.SetRate(rate)
+			fmt.Println("-------------------")
+		} else if option == "10" {
+			if retryAfter := limiter.Check(client, Structures.OpScan, 1); retryAfter == 0 {
+				var start, end string
+				fmt.Print("Enter start key: ")
+				_, err := fmt.Scanln(&start)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				fmt.Print("Enter end key: ")
+				_, err = fmt.Scanln(&end)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				fmt.Println("-------------------")
+				rows := 0
+				for _, record := range scan(start, end, memtable, store) {
+					if !record.Tombstone && !Structures.IsSystemKey(record.Key) {
+						fmt.Println(record.Key, ":", string(record.Value))
+						rows++
+					}
+				}
+				limiter.Charge(client, Structures.OpScan, rows)
+				fmt.Println("-------------------")
+			} else {
+				rejected(retryAfter)
+			}
+		} else if option == "12" {
+			evictions.print()
+			fmt.Println("-------------------")
+		} else if option == "11" {
+			if store.BlockCache == nil {
+				fmt.Println("Block cache is disabled.")
+			} else {
+				store.BlockCache.Info()
+			}
+			fmt.Println("-------------------")
+		} else if option == "13" {
+			if store.NegativeCache == nil {
+				fmt.Println("Negative cache is disabled.")
+			} else {
+				store.NegativeCache.Info()
+			}
+			fmt.Println("-------------------")
+		} else if option == "14" {
+			limiter.Info()
+			fmt.Println("-------------------")
+		} else if option == "15" {
+			var n int
+			fmt.Print("Enter number of keys: ")
+			_, err := fmt.Scanln(&n)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			for i, hitter := range sketches.TopK.TopK(n) {
+				fmt.Println(i+1, hitter.Key, "frequency:", hitter.Count, "(error:", hitter.Error, ")")
+			}
+			fmt.Println("-------------------")
+		} else if option == "16" {
+			var prefix string
+			fmt.Println("Prefixes:", sketches.DistinctKeys.Prefixes()[1:])
+			fmt.Print("Enter prefix (* for all keys): ")
+			_, err := fmt.Scanln(&prefix)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			if prefix == "*" {
+				prefix = Structures.AllKeys
+			}
+			count, err := sketches.DistinctKeys.CountDistinct(prefix)
+			if err != nil {
+				fmt.Println(err)
+			} else {
+				fmt.Println("Distinct keys:", count)
+			}
+			fmt.Println("-------------------")
+		} else if option == "17" {
+			sketches.Info()
+			fmt.Println("-------------------")
+		} else if option == "18" {
+			tableStats(config)
+		} else if option == "19" {
+			verify(config)
+		} else if option == "20" {
+			if retryAfter := limiter.Check(client, Structures.OpGet, 1); retryAfter == 0 {
+				ok, err := readKey(&key)
+				if err != nil {
+					fmt.Println(err)
+					return
+				}
+				if !ok {
+					continue
+				}
+				fmt.Println("-------------------")
+				proof, err := Structures.GetWithProof(key, memtable, config, store.BlockCache)
+				limiter.Charge(client, Structures.OpGet, 1)
+				if err != nil {
+					fmt.Println(err)
+				} else {
+					proof.Info()
+					fmt.Println("Proof valid:", proof.Verify())
+				}
+				fmt.Println("-------------------")
+			} else {
+				rejected(retryAfter)
+			}
+		} else if option == "21" || option == "22" {
+			var path string
+			fmt.Print("Enter directory of the other store: ")
+			_, err := fmt.Scanln(&path)
+			if err != nil {
+				fmt.Println(err)
+				return
+			}
+			fmt.Println("-------------------")
+			if option == "21" {
+				diff(path, config)
+			} else {
+				repair(path, store, func(key string, value []byte, tombstone string) {
+					putDel(key, value, tombstone, wal, memtable, controller, store)
+				})
+			}
+			fmt.Println("-------------------")
 		} else {
 			fmt.Println("Invalid option!")
 			fmt.Println("-------------------")
 		}
 	}
-	cms.SerializeCMS("CMS_HLL/cms.dat")
-	hll.Serialize("CMS_HLL/hll.dat")
+	if sketches.Operations() > 0 {
+		checkpoint(sketches, wal, memtable, store)
+	}
 }
 
 func main() {
-	menu()
+	trace := flag.String("cache-trace", "", "replay the key trace from the given file on all cache policies")
+	client := flag.String("client", Structures.DefaultClient, "name of the client whose token bucket is used")
+	flag.Parse()
+	if *trace != "" {
+		keys, err := Structures.ReadTrace(*trace)
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		config, err := Structures.NewConfig("configuration.yaml")
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		Structures.CompareCachePolicies(keys, int(config.CacheSize))
+		return
+	}
+	menu(*client)
 }
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"ProjekatGO/Structures"
+	"os"
+	"testing"
+	"time"
+)
+
+// newTestConfig makes an empty temporary directory the working directory and returns the default configuration with
+// the given WAL segment size.
+func newTestConfig(t *testing.T, walSize uint64) *Structures.Config {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Chdir(wd)
+	})
+	for _, dir := range []string{Structures.DirectoryPath, "LSM", "CMS_HLL"} {
+		if err := os.Mkdir(dir, 0777); err != nil {
+			t.Fatal(err)
+		}
+	}
+	config, err := Structures.NewConfig("configuration.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.WalSize = walSize
+	Structures.Lsm{}.GenerateLevels(config)
+	return config
+}
+
+func TestSketchCheckpointSurvivesCrash(t *testing.T) {
+	config := newTestConfig(t, 2)
+	store := Structures.NewStore(config)
+	wal, memtable := loadMemtable(config)
+	store.Cache = createCache(config)
+	controller := Structures.NewWriteController(store)
+	sketches := loadSketches(memtable, config, store.BlockCache)
+	for _, key := range []string{"a", "b", "a"} {
+		put(key, []byte("1"), wal, memtable, controller, store)
+		sketches.RecordPut(key, []byte("1"))
+	}
+	checkpoint(sketches, wal, memtable, store)
+	checkpointed := sketches.Bytes()
+	// Later writes fill new segments, the checkpoint stays in an older one.
+	for _, key := range []string{"c", "d", "e", "f"} {
+		put(key, []byte("2"), wal, memtable, controller, store)
+	}
+	segments, err := wal.Segments()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 3 {
+		t.Fatalf("records are in %d WAL segments, the test needs at least 3", len(segments))
+	}
+
+	// The process crashes, the memtable is only in the WAL.
+	wal, memtable = loadMemtable(config)
+	for _, key := range []string{"a", "b", "c", "d", "e", "f"} {
+		if memtable.SkipList().FindDeleted(key) == nil {
+			t.Errorf("%s is lost after the restart", key)
+		}
+	}
+	restored := loadSketches(memtable, config, store.BlockCache)
+	if string(restored.Bytes()) != string(checkpointed) {
+		t.Error("sketches differ from the checkpoint after the restart")
+	}
+	if estimate := restored.CMS.Estimate("a"); estimate != 2 {
+		t.Errorf("a estimated %d times after the restart, expected 2", estimate)
+	}
+	if wal.ActiveSegmentPath != segments[len(segments)-1] {
+		t.Errorf("writing continues in %s, expected the newest segment %s", wal.ActiveSegmentPath,
+			segments[len(segments)-1])
+	}
+}
+
+func TestReadOptionCallsIdleWhileWaiting(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = reader
+	defer func() {
+		os.Stdin = stdin
+		_ = reader.Close()
+	}()
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		_, _ = writer.Write([]byte("7\n"))
+		_ = writer.Close()
+	}()
+	idle := 0
+	option, err := readOption(10*time.Millisecond, func() {
+		idle++
+	})
+	if err != nil || option != "7" {
+		t.Fatalf("read option %q, %v, expected 7", option, err)
+	}
+	if idle < 2 {
+		t.Errorf("idle is called %d times while waiting, expected at least 2", idle)
+	}
+}